@@ -0,0 +1,77 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/feline-dis/go-radio/internal/download"
+	"github.com/feline-dis/go-radio/internal/ingest"
+)
+
+// DownloadProgressPayload is how far the download of a song has got. Speed is
+// in bytes per second and ETA in seconds; both are 0 when unknown.
+type DownloadProgressPayload struct {
+	ID         string       `json:"id"`
+	State      string       `json:"state"`
+	Percent    float64      `json:"percent"`
+	Downloaded int64        `json:"downloaded"`
+	Total      int64        `json:"total"`
+	Speed      float64      `json:"speed"`
+	ETA        float64      `json:"eta"`
+	Song       *SongPayload `json:"song,omitempty"`
+}
+
+// NewDownloadProgressPayload converts download progress into its API
+// representation. song may be nil if it is not known.
+func NewDownloadProgressPayload(progress download.Progress, song *ingest.Song) *DownloadProgressPayload {
+	payload := &DownloadProgressPayload{
+		ID:         progress.ID,
+		State:      progress.State.String(),
+		Percent:    progress.Percent(),
+		Downloaded: progress.Downloaded,
+		Total:      progress.Total,
+		Speed:      progress.Speed,
+		ETA:        progress.ETA.Round(time.Second).Seconds(),
+	}
+
+	if progress.State == download.StateDone {
+		payload.Percent = 100
+	}
+
+	if song != nil {
+		payload.Song = NewSongPayload(song)
+	}
+
+	return payload
+}
+
+// AdminController serves endpoints for operating the server.
+type AdminController struct {
+	downloadService *download.DownloadService
+	songs           SongFinder
+}
+
+func NewAdminController(downloadService *download.DownloadService, songs SongFinder) *AdminController {
+	return &AdminController{
+		downloadService: downloadService,
+		songs:           songs,
+	}
+}
+
+func (ac *AdminController) RegisterRoutes(r *http.ServeMux) {
+	r.HandleFunc("GET /api/admin/downloads", ac.getDownloads)
+	fmt.Println("admin routes registered")
+}
+
+// getDownloads lists the downloads that are queued, in progress or failed.
+func (ac *AdminController) getDownloads(w http.ResponseWriter, r *http.Request) {
+	jobs := ac.downloadService.Jobs()
+
+	downloads := make([]*DownloadProgressPayload, 0, len(jobs))
+	for _, job := range jobs {
+		downloads = append(downloads, NewDownloadProgressPayload(job, ac.songs.GetSong(job.ID)))
+	}
+
+	writeJSON(w, http.StatusOK, downloads)
+}
@@ -0,0 +1,378 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/feline-dis/go-radio/internal/history"
+	"github.com/feline-dis/go-radio/internal/ingest"
+)
+
+const (
+	defaultHistoryLimit = 20
+	maxHistoryLimit     = 100
+
+	defaultStatsLimit = 10
+	maxStatsLimit     = 100
+	// defaultStatsDays is the window used by the stats endpoints, suited to
+	// weekly recaps.
+	defaultStatsDays = 7
+)
+
+// Station exposes the live state of a station to the API.
+type Station interface {
+	// Name returns the station's name.
+	Name() string
+	// Listeners returns the number of connected listeners.
+	Listeners() int
+	// Library returns every song the station can play.
+	Library() []*ingest.Song
+	// NowPlaying returns the current song or nil if nothing is playing yet.
+	NowPlaying() *NowPlayingPayload
+	// UpNext returns the song that plays after the current one or nil.
+	UpNext() *SongPayload
+	// History returns up to limit recently played songs, most recent first.
+	History(limit int) []*HistoryEntryPayload
+}
+
+type SubmitterPayload struct {
+	Name   string `json:"name"`
+	PfpURL string `json:"pfp_url"`
+}
+
+type SongPayload struct {
+	ID        string            `json:"id"`
+	Artist    string            `json:"artist"`
+	Title     string            `json:"title"`
+	Album     string            `json:"album,omitempty"`
+	ArtUrl    string            `json:"art_url"`
+	URL       string            `json:"url"`
+	Submitter *SubmitterPayload `json:"submitter,omitempty"`
+}
+
+// SubmitterSongsPayload is a submitter with the songs they added.
+type SubmitterSongsPayload struct {
+	SubmitterPayload
+	Songs []*SongPayload `json:"songs"`
+}
+
+type NowPlayingPayload struct {
+	CurrentSongPayload
+	Elapsed   float64 `json:"elapsed"`
+	Remaining float64 `json:"remaining"`
+}
+
+type HistoryEntryPayload struct {
+	Song      *SongPayload `json:"song"`
+	StartTime string       `json:"start_time"`
+	EndTime   string       `json:"end_time"`
+	Listeners int          `json:"listeners"`
+	Skipped   bool         `json:"skipped"`
+}
+
+type SongPlaysPayload struct {
+	ID     string `json:"id"`
+	Artist string `json:"artist"`
+	Title  string `json:"title"`
+	Plays  int    `json:"plays"`
+}
+
+type SubmitterPlaysPayload struct {
+	Submitter string `json:"submitter"`
+	Plays     int    `json:"plays"`
+}
+
+type StationPayload struct {
+	Name       string             `json:"name"`
+	Listeners  int                `json:"listeners"`
+	NowPlaying *NowPlayingPayload `json:"now_playing,omitempty"`
+}
+
+// ErrorPayload describes an error. Code is only set in websocket error frames.
+type ErrorPayload struct {
+	Error string `json:"error"`
+	Code  string `json:"code,omitempty"`
+}
+
+// NewSubmitterPayload converts a submitter into its API representation.
+func NewSubmitterPayload(submitter ingest.Submitter) *SubmitterPayload {
+	return &SubmitterPayload{
+		Name:   submitter.Name,
+		PfpURL: submitter.Pfp,
+	}
+}
+
+// NewSongPayload converts a song into its API representation.
+func NewSongPayload(song *ingest.Song) *SongPayload {
+	payload := &SongPayload{
+		ID:     song.ID(),
+		Artist: song.Artist,
+		Title:  song.Title,
+		Album:  song.Album,
+		ArtUrl: song.ArtUrl,
+		URL:    song.URL,
+	}
+
+	if song.Submitter != nil {
+		payload.Submitter = NewSubmitterPayload(*song.Submitter)
+	}
+
+	return payload
+}
+
+type APIController struct {
+	station      Station
+	historyStore *history.Store
+}
+
+// NewAPIController creates a new API controller. historyStore may be nil, in
+// which case the stats endpoints are unavailable.
+func NewAPIController(station Station, historyStore *history.Store) *APIController {
+	return &APIController{
+		station:      station,
+		historyStore: historyStore,
+	}
+}
+
+// RegisterRoutes serves the station's API under prefix.
+func (ac *APIController) RegisterRoutes(r *http.ServeMux, prefix string) {
+	r.HandleFunc("GET "+prefix+"/now-playing", ac.getNowPlaying)
+	r.HandleFunc("GET "+prefix+"/next", ac.getNext)
+	r.HandleFunc("GET "+prefix+"/history", ac.getHistory)
+	r.HandleFunc("GET "+prefix+"/library", ac.getLibrary)
+	r.HandleFunc("GET "+prefix+"/submitters", ac.getSubmitters)
+	r.HandleFunc("GET "+prefix+"/submitters/{name}", ac.getSubmitter)
+	r.HandleFunc("GET "+prefix+"/stats/songs", ac.getSongStats)
+	r.HandleFunc("GET "+prefix+"/stats/submitters", ac.getSubmitterStats)
+	fmt.Println("api routes registered")
+}
+
+func (ac *APIController) getNowPlaying(w http.ResponseWriter, r *http.Request) {
+	nowPlaying := ac.station.NowPlaying()
+	if nowPlaying == nil {
+		writeError(w, http.StatusServiceUnavailable, "Nothing is playing yet")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, nowPlaying)
+}
+
+func (ac *APIController) getNext(w http.ResponseWriter, r *http.Request) {
+	next := ac.station.UpNext()
+	if next == nil {
+		writeError(w, http.StatusServiceUnavailable, "Nothing is queued yet")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, next)
+}
+
+func (ac *APIController) getHistory(w http.ResponseWriter, r *http.Request) {
+	limit, err := parseLimit(r, defaultHistoryLimit, maxHistoryLimit)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, ac.station.History(limit))
+}
+
+func (ac *APIController) getLibrary(w http.ResponseWriter, r *http.Request) {
+	songs := ac.station.Library()
+
+	library := make([]*SongPayload, 0, len(songs))
+	for _, song := range songs {
+		library = append(library, NewSongPayload(song))
+	}
+
+	writeJSON(w, http.StatusOK, library)
+}
+
+func (ac *APIController) getSubmitters(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, ac.submitterSongs())
+}
+
+func (ac *APIController) getSubmitter(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	for _, submitter := range ac.submitterSongs() {
+		if submitter.Name == name {
+			writeJSON(w, http.StatusOK, submitter)
+			return
+		}
+	}
+
+	writeError(w, http.StatusNotFound, "Submitter not found")
+}
+
+// submitterSongs groups the station's library by submitter, ordered by name.
+// Songs without a submitter are left out.
+func (ac *APIController) submitterSongs() []*SubmitterSongsPayload {
+	bySubmitter := make(map[string]*SubmitterSongsPayload)
+	for _, song := range ac.station.Library() {
+		if song.Submitter == nil {
+			continue
+		}
+
+		submitter, exists := bySubmitter[song.Submitter.Name]
+		if !exists {
+			submitter = &SubmitterSongsPayload{
+				SubmitterPayload: *NewSubmitterPayload(*song.Submitter),
+				Songs:            make([]*SongPayload, 0),
+			}
+			bySubmitter[song.Submitter.Name] = submitter
+		}
+
+		// The submitter is already given once for the whole list.
+		payload := NewSongPayload(song)
+		payload.Submitter = nil
+		submitter.Songs = append(submitter.Songs, payload)
+	}
+
+	submitters := make([]*SubmitterSongsPayload, 0, len(bySubmitter))
+	for _, submitter := range bySubmitter {
+		submitters = append(submitters, submitter)
+	}
+	sort.Slice(submitters, func(i, j int) bool {
+		return submitters[i].Name < submitters[j].Name
+	})
+
+	return submitters
+}
+
+func (ac *APIController) getSongStats(w http.ResponseWriter, r *http.Request) {
+	since, limit, ok := ac.statsQuery(w, r)
+	if !ok {
+		return
+	}
+
+	counts, err := ac.historyStore.PlaysPerSong(ac.station.Name(), since, limit)
+	if err != nil {
+		fmt.Printf("failed to query song stats: %v\n", err)
+		writeError(w, http.StatusInternalServerError, "Failed to query stats")
+		return
+	}
+
+	stats := make([]*SongPlaysPayload, 0, len(counts))
+	for _, count := range counts {
+		stats = append(stats, &SongPlaysPayload{
+			ID:     count.SongID,
+			Artist: count.Artist,
+			Title:  count.Title,
+			Plays:  count.Plays,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, stats)
+}
+
+func (ac *APIController) getSubmitterStats(w http.ResponseWriter, r *http.Request) {
+	since, limit, ok := ac.statsQuery(w, r)
+	if !ok {
+		return
+	}
+
+	counts, err := ac.historyStore.PlaysPerSubmitter(ac.station.Name(), since, limit)
+	if err != nil {
+		fmt.Printf("failed to query submitter stats: %v\n", err)
+		writeError(w, http.StatusInternalServerError, "Failed to query stats")
+		return
+	}
+
+	stats := make([]*SubmitterPlaysPayload, 0, len(counts))
+	for _, count := range counts {
+		stats = append(stats, &SubmitterPlaysPayload{
+			Submitter: count.Submitter,
+			Plays:     count.Plays,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, stats)
+}
+
+// statsQuery parses the "days" and "limit" parameters shared by the stats
+// endpoints. It writes an error response and returns false if the request
+// cannot be served.
+func (ac *APIController) statsQuery(w http.ResponseWriter, r *http.Request) (time.Time, int, bool) {
+	if ac.historyStore == nil {
+		writeError(w, http.StatusNotFound, "Play history is not persisted")
+		return time.Time{}, 0, false
+	}
+
+	limit, err := parseLimit(r, defaultStatsLimit, maxStatsLimit)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return time.Time{}, 0, false
+	}
+
+	days := defaultStatsDays
+	if value := r.URL.Query().Get("days"); value != "" {
+		days, err = strconv.Atoi(value)
+		if err != nil || days < 1 {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid days %q", value))
+			return time.Time{}, 0, false
+		}
+	}
+
+	return time.Now().AddDate(0, 0, -days), limit, true
+}
+
+// parseLimit reads the "limit" query parameter, falling back to def and
+// clamping to maxLimit.
+func parseLimit(r *http.Request, def, maxLimit int) (int, error) {
+	value := r.URL.Query().Get("limit")
+	if value == "" {
+		return def, nil
+	}
+
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 1 {
+		return 0, fmt.Errorf("invalid limit %q", value)
+	}
+
+	return min(limit, maxLimit), nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		fmt.Printf("failed to encode response: %v\n", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, &ErrorPayload{Error: message})
+}
+
+// StationsController lists every station served by this process.
+type StationsController struct {
+	stations []Station
+}
+
+func NewStationsController(stations []Station) *StationsController {
+	return &StationsController{
+		stations: stations,
+	}
+}
+
+func (sc *StationsController) RegisterRoutes(r *http.ServeMux) {
+	r.HandleFunc("GET /api/stations", sc.getStations)
+	fmt.Println("station routes registered")
+}
+
+func (sc *StationsController) getStations(w http.ResponseWriter, r *http.Request) {
+	stations := make([]*StationPayload, 0, len(sc.stations))
+	for _, station := range sc.stations {
+		stations = append(stations, &StationPayload{
+			Name:       station.Name(),
+			Listeners:  station.Listeners(),
+			NowPlaying: station.NowPlaying(),
+		})
+	}
+
+	writeJSON(w, http.StatusOK, stations)
+}
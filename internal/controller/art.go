@@ -0,0 +1,52 @@
+package controller
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/feline-dis/go-radio/internal/ingest"
+)
+
+// ArtController serves cover art embedded in local library songs.
+type ArtController struct {
+	r     *http.ServeMux
+	songs SongFinder
+}
+
+func NewArtController(r *http.ServeMux, songs SongFinder) *ArtController {
+	return &ArtController{
+		r:     r,
+		songs: songs,
+	}
+}
+
+func (ac *ArtController) RegisterRoutes() {
+	ac.r.HandleFunc("GET "+ingest.ArtURL("{id}"), ac.getArt)
+	fmt.Println("art routes registered")
+}
+
+func (ac *ArtController) getArt(w http.ResponseWriter, r *http.Request) {
+	song := ac.songs.GetSong(r.PathValue("id"))
+	if song == nil || !song.IsLocal() {
+		http.NotFound(w, r)
+		return
+	}
+
+	picture, err := song.Art()
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	contentType := picture.MIMEType
+	if contentType == "" {
+		contentType = http.DetectContentType(picture.Data)
+	}
+
+	// Song IDs are content hashes, so the art never changes.
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "public, max-age=86400, immutable")
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(picture.Data))
+}
@@ -0,0 +1,91 @@
+package controller
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// TrustedProxies are the reverse proxies whose X-Forwarded-For header is
+// believed. Listeners are identified by their own address otherwise, since the
+// header is set by the client and would let them evade rate limits.
+type TrustedProxies []netip.Prefix
+
+// ParseTrustedProxies parses a comma separated list of IP addresses and CIDR
+// ranges.
+func ParseTrustedProxies(value string) (TrustedProxies, error) {
+	var proxies TrustedProxies
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		if !strings.Contains(field, "/") {
+			addr, err := netip.ParseAddr(field)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse trusted proxy %q: %w", field, err)
+			}
+			proxies = append(proxies, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(field)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse trusted proxy %q: %w", field, err)
+		}
+		proxies = append(proxies, prefix.Masked())
+	}
+
+	return proxies, nil
+}
+
+// trusts reports whether address is one of the proxies.
+func (tp TrustedProxies) trusts(address string) bool {
+	addr, err := netip.ParseAddr(address)
+	if err != nil {
+		return false
+	}
+
+	addr = addr.Unmap()
+	for _, prefix := range tp {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the address of the client that made the request. When the
+// request comes from a trusted proxy, X-Forwarded-For is followed back to the
+// first address that is not a trusted proxy.
+func (tp TrustedProxies) ClientIP(r *http.Request) string {
+	remote, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remote = r.RemoteAddr
+	}
+
+	if !tp.trusts(remote) {
+		return remote
+	}
+
+	// Every proxy appends the address it received the request from, so the
+	// rightmost entries are the only ones that can be believed.
+	client := remote
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		address := strings.TrimSpace(forwarded[i])
+		if address == "" {
+			continue
+		}
+
+		client = address
+		if !tp.trusts(address) {
+			break
+		}
+	}
+
+	return client
+}
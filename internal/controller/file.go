@@ -1,25 +1,44 @@
 package controller
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/feline-dis/go-radio/internal/download"
 	"github.com/feline-dis/go-radio/internal/ingest"
 )
 
+// downloadWaitTimeout is how long a request waits for a pending download
+// before the client is told to retry.
+const downloadWaitTimeout = 30 * time.Second
+
+const (
+	retryAfterDownloading = 5 * time.Second
+	retryAfterFailed      = 30 * time.Second
+)
+
+// SongFinder looks up songs by ID.
+type SongFinder interface {
+	GetSong(id string) *ingest.Song
+}
+
 type FileController struct {
 	r               *http.ServeMux
 	downloadService *download.DownloadService
-	dataService     *ingest.DataService
+	songs           SongFinder
 }
 
-func NewFileController(r *http.ServeMux, downloadService *download.DownloadService, dataService *ingest.DataService) *FileController {
+func NewFileController(r *http.ServeMux, downloadService *download.DownloadService, songs SongFinder) *FileController {
 	return &FileController{
 		r:               r,
 		downloadService: downloadService,
-		dataService:     dataService,
+		songs:           songs,
 	}
 }
 
@@ -36,27 +55,61 @@ func (fc *FileController) RegisterRoutes() {
 func (fc *FileController) getFile(w http.ResponseWriter, r *http.Request) {
 	id := strings.TrimPrefix(r.URL.Path, "/file/")
 	fmt.Println("getting file", id)
-	song := fc.dataService.GetSong(id)
+	song := fc.songs.GetSong(id)
 
-	if song == nil {
+	if song != nil {
+		if err := fc.downloadService.EnsureDownloaded(song); err != nil {
+			setRetryAfter(w, retryAfterFailed)
+			http.Error(w, "Failed to queue download", http.StatusServiceUnavailable)
+			return
+		}
+	} else if state := fc.downloadService.State(id); state == download.StateNone || state == download.StateFailed {
+		// Requested songs are not in the library but are still served once
+		// the station has downloaded them.
 		http.Error(w, "Song not found", http.StatusNotFound)
 		return
 	}
 
-	if err := fc.downloadService.EnsureDownloaded(song); err != nil {
-		http.Error(w, "Failed to queue download", http.StatusInternalServerError)
+	ctx, cancel := context.WithTimeout(r.Context(), downloadWaitTimeout)
+	defer cancel()
+
+	info, err := fc.downloadService.WaitForDownload(ctx, id)
+	switch {
+	case err == nil:
+	case r.Context().Err() != nil:
+		// client went away
+		return
+	case errors.Is(err, context.DeadlineExceeded):
+		setRetryAfter(w, retryAfterDownloading)
+		http.Error(w, "Song is still downloading", http.StatusAccepted)
+		return
+	default:
+		fmt.Printf("failed to serve file %s: %v\n", id, err)
+		setRetryAfter(w, retryAfterFailed)
+		http.Error(w, "Song download failed", http.StatusServiceUnavailable)
 		return
 	}
 
-	for {
-		info, exists := fc.downloadService.GetDownload(song.ID())
-
-		if !exists {
-			fmt.Println("waiting for download")
-			continue
-		}
+	file, err := os.Open(info.Path)
+	if err != nil {
+		http.Error(w, "Failed to open file", http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
 
-		http.ServeFile(w, r, fc.downloadService.CachePath+"/"+info.FileInfo.Name())
-		break
+	stat, err := file.Stat()
+	if err != nil {
+		http.Error(w, "Failed to stat file", http.StatusInternalServerError)
+		return
 	}
+
+	// ServeContent takes care of Range, If-Range, If-None-Match and
+	// If-Modified-Since using the ETag set here and the modification time.
+	w.Header().Set("ETag", fmt.Sprintf(`"%s-%x-%x"`, id, stat.Size(), stat.ModTime().UnixNano()))
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	http.ServeContent(w, r, stat.Name(), stat.ModTime(), file)
+}
+
+func setRetryAfter(w http.ResponseWriter, d time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(d.Seconds())))
 }
@@ -0,0 +1,70 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/feline-dis/go-radio/internal/stream"
+)
+
+type HLSController struct {
+	segmenter *stream.Segmenter
+}
+
+func NewHLSController(segmenter *stream.Segmenter) *HLSController {
+	return &HLSController{
+		segmenter: segmenter,
+	}
+}
+
+// RegisterRoutes serves the playlist and its segments under prefix.
+func (hc *HLSController) RegisterRoutes(r *http.ServeMux, prefix string) {
+	r.HandleFunc(prefix+"/live.m3u8", hc.getPlaylist)
+	r.HandleFunc(prefix+"/{segment}", hc.getSegment)
+	fmt.Println("hls routes registered")
+}
+
+func (hc *HLSController) getPlaylist(w http.ResponseWriter, r *http.Request) {
+	playlist := hc.segmenter.Playlist(func(sequence int) string {
+		return fmt.Sprintf("segment%d.mp3", sequence)
+	})
+
+	// Let caches hold the playlist for half a segment so clients still see
+	// new segments promptly.
+	maxAge := int(hc.segmenter.TargetDuration().Seconds() / 2)
+
+	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", maxAge))
+	w.Write(playlist)
+}
+
+func (hc *HLSController) getSegment(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("segment")
+	if !strings.HasPrefix(name, "segment") || !strings.HasSuffix(name, ".mp3") {
+		http.Error(w, "Segment not found", http.StatusNotFound)
+		return
+	}
+
+	sequence, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(name, "segment"), ".mp3"))
+	if err != nil {
+		http.Error(w, "Segment not found", http.StatusNotFound)
+		return
+	}
+
+	segment, ok := hc.segmenter.Segment(sequence)
+	if !ok {
+		http.Error(w, "Segment not found", http.StatusNotFound)
+		return
+	}
+
+	// Segments never change once published.
+	w.Header().Set("Content-Type", "audio/mpeg")
+	w.Header().Set("Cache-Control", "public, max-age=86400, immutable")
+	w.Header().Set("Content-Length", strconv.Itoa(len(segment.Data)))
+	w.Header().Set("Last-Modified", segment.ProgramDateTime.Add(segment.Duration).UTC().Format(http.TimeFormat))
+	w.Header().Set("Expires", time.Now().Add(24*time.Hour).UTC().Format(http.TimeFormat))
+	w.Write(segment.Data)
+}
@@ -0,0 +1,165 @@
+package controller
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+// ProtocolVersion is the version of the websocket protocol spoken by this
+// server. It changes whenever a message changes incompatibly.
+const ProtocolVersion = 1
+
+// ServerName identifies this server in the welcome message.
+const ServerName = "go-radio"
+
+// protocolSchema is the JSON schema of every message, served at /api/protocol.
+//
+//go:embed protocol.json
+var protocolSchema []byte
+
+// Error codes sent in error frames.
+const (
+	ErrorCodeMalformedMessage   = "malformed_message"
+	ErrorCodeUnknownType        = "unknown_type"
+	ErrorCodeUnsupportedVersion = "unsupported_version"
+	ErrorCodeInvalidPayload     = "invalid_payload"
+	ErrorCodeCommandFailed      = "command_failed"
+)
+
+// ProtocolError is an error with a code clients can act on. Handlers return
+// it to choose the code of the error frame; other errors are sent with
+// ErrorCodeCommandFailed.
+type ProtocolError struct {
+	Code    string
+	Message string
+}
+
+func (e *ProtocolError) Error() string {
+	return e.Message
+}
+
+// InvalidPayload returns the error for a command whose payload could not be
+// decoded.
+func InvalidPayload(messageType MessageType) error {
+	return &ProtocolError{
+		Code:    ErrorCodeInvalidPayload,
+		Message: fmt.Sprintf("invalid %s payload", messageType),
+	}
+}
+
+// HelloPayload opens the handshake. Clients send it first to learn what the
+// server supports; it is optional for clients that only listen.
+type HelloPayload struct {
+	ProtocolVersion int    `json:"protocol_version"`
+	Client          string `json:"client,omitempty"`
+}
+
+// WelcomePayload answers hello.
+type WelcomePayload struct {
+	ProtocolVersion int           `json:"protocol_version"`
+	Server          string        `json:"server"`
+	ClientID        uint64        `json:"client_id"`
+	Capabilities    *Capabilities `json:"capabilities"`
+}
+
+// Capabilities lists the messages a server handles and sends.
+type Capabilities struct {
+	// Commands are the messages clients may send.
+	Commands []MessageType `json:"commands"`
+	// Events are the messages the server sends without being asked.
+	Events []MessageType `json:"events"`
+}
+
+// events are the messages broadcast to clients.
+var events = []MessageType{
+	MessageTypeCurrentSong,
+	MessageTypeQueue,
+	MessageTypeSkipVotes,
+	MessageTypeDownloadProgress,
+}
+
+// handleHello answers the handshake with the server's version and
+// capabilities.
+func (wsc *WebsocketController) handleHello(client *Client, raw json.RawMessage) (*Message, error) {
+	var payload HelloPayload
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		return nil, InvalidPayload(MessageTypeHello)
+	}
+
+	if payload.ProtocolVersion != ProtocolVersion {
+		return nil, &ProtocolError{
+			Code:    ErrorCodeUnsupportedVersion,
+			Message: fmt.Sprintf("protocol version %d is not supported, this server speaks version %d", payload.ProtocolVersion, ProtocolVersion),
+		}
+	}
+
+	wsc.mu.RLock()
+	commands := make([]MessageType, 0, len(wsc.handlers))
+	for messageType := range wsc.handlers {
+		commands = append(commands, messageType)
+	}
+	wsc.mu.RUnlock()
+	sort.Slice(commands, func(i, j int) bool {
+		return commands[i] < commands[j]
+	})
+
+	return &Message{
+		Type: MessageTypeWelcome,
+		Payload: &WelcomePayload{
+			ProtocolVersion: ProtocolVersion,
+			Server:          ServerName,
+			ClientID:        client.ID,
+			Capabilities: &Capabilities{
+				Commands: commands,
+				Events:   events,
+			},
+		},
+	}, nil
+}
+
+// handlePing lets clients that cannot send websocket pings check the
+// connection.
+func (wsc *WebsocketController) handlePing(client *Client, raw json.RawMessage) (*Message, error) {
+	return &Message{Type: MessageTypePong}, nil
+}
+
+// newErrorFrame builds the error frame for err.
+func newErrorFrame(err error) *Message {
+	var protocolErr *ProtocolError
+	if !errors.As(err, &protocolErr) {
+		protocolErr = &ProtocolError{
+			Code:    ErrorCodeCommandFailed,
+			Message: err.Error(),
+		}
+	}
+
+	return &Message{
+		Type: MessageTypeError,
+		Payload: &ErrorPayload{
+			Error: protocolErr.Message,
+			Code:  protocolErr.Code,
+		},
+	}
+}
+
+// ProtocolController publishes the websocket protocol.
+type ProtocolController struct{}
+
+func NewProtocolController() *ProtocolController {
+	return &ProtocolController{}
+}
+
+func (pc *ProtocolController) RegisterRoutes(r *http.ServeMux) {
+	r.HandleFunc("GET /api/protocol", pc.getProtocol)
+	fmt.Println("protocol routes registered")
+}
+
+// getProtocol serves the JSON schema of the websocket protocol.
+func (pc *ProtocolController) getProtocol(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/schema+json")
+	w.Write(protocolSchema)
+}
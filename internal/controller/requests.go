@@ -0,0 +1,212 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/feline-dis/go-radio/internal/ingest"
+	"github.com/feline-dis/go-radio/internal/picker"
+	"github.com/feline-dis/go-radio/internal/source"
+)
+
+// maxRequestBody is the largest request body accepted by POST /requests.
+const maxRequestBody = 4 * 1024
+
+// RequestSongPayload asks for a song from the library by ID or a new URL.
+type RequestSongPayload struct {
+	ID     string `json:"id"`
+	URL    string `json:"url"`
+	Title  string `json:"title"`
+	Artist string `json:"artist"`
+	// Name is who the request should be credited to.
+	Name string `json:"name"`
+}
+
+type RequestPayload struct {
+	Song        *SongPayload `json:"song"`
+	Name        string       `json:"name"`
+	RequestedAt string       `json:"requested_at"`
+	Position    int          `json:"position"`
+}
+
+type RequestController struct {
+	// TrustedProxies are the proxies allowed to forward the address of a
+	// listener, which identifies them for rate limiting.
+	TrustedProxies TrustedProxies
+	requests       *picker.RequestQueue
+	dataService    *ingest.DataService
+}
+
+func NewRequestController(requests *picker.RequestQueue, dataService *ingest.DataService) *RequestController {
+	return &RequestController{
+		requests:    requests,
+		dataService: dataService,
+	}
+}
+
+// RegisterRoutes serves the request queue under prefix.
+func (rc *RequestController) RegisterRoutes(r *http.ServeMux, prefix string) {
+	r.HandleFunc("GET "+prefix+"/requests", rc.getRequests)
+	r.HandleFunc("POST "+prefix+"/requests", rc.postRequest)
+	fmt.Println("request routes registered")
+}
+
+// RegisterHandlers accepts requests sent over the websocket.
+func (rc *RequestController) RegisterHandlers(wsc *WebsocketController) {
+	wsc.HandleMessage(MessageTypeRequestSong, rc.handleRequestSong)
+}
+
+func (rc *RequestController) getRequests(w http.ResponseWriter, r *http.Request) {
+	pending := rc.requests.Pending()
+
+	requests := make([]*RequestPayload, 0, len(pending))
+	for i, request := range pending {
+		requests = append(requests, newRequestPayload(request, i+1))
+	}
+
+	writeJSON(w, http.StatusOK, requests)
+}
+
+func (rc *RequestController) postRequest(w http.ResponseWriter, r *http.Request) {
+	var payload RequestSongPayload
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBody)).Decode(&payload); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	request, err := rc.submit(rc.TrustedProxies.ClientIP(r), &payload)
+	if err != nil {
+		writeError(w, requestErrorStatus(err), err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, request)
+}
+
+func (rc *RequestController) handleRequestSong(client *Client, raw json.RawMessage) (*Message, error) {
+	var payload RequestSongPayload
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		return nil, InvalidPayload(MessageTypeRequestSong)
+	}
+
+	request, err := rc.submit(client.RemoteAddr, &payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Message{
+		Type:    MessageTypeRequestAccepted,
+		Payload: request,
+	}, nil
+}
+
+// submit resolves the requested song and adds it to the queue on behalf of requester.
+func (rc *RequestController) submit(requester string, payload *RequestSongPayload) (*RequestPayload, error) {
+	song, err := rc.resolveSong(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	request := &picker.Request{
+		Song:      song,
+		Requester: requester,
+		Name:      payload.Name,
+	}
+
+	if err := rc.requests.Submit(request); err != nil {
+		return nil, err
+	}
+
+	position := 0
+	for i, pending := range rc.requests.Pending() {
+		if pending == request {
+			position = i + 1
+			break
+		}
+	}
+
+	fmt.Printf("song %s requested by %s\n", song.ID(), requester)
+
+	return newRequestPayload(request, position), nil
+}
+
+var (
+	errSongNotFound   = errors.New("song not found in the library")
+	errInvalidRequest = errors.New("a song id or url is required")
+	errSourceDenied   = errors.New("songs from this source cannot be requested")
+)
+
+// resolveSong finds the requested song in the library, or creates a new song
+// for a URL that is not in it.
+func (rc *RequestController) resolveSong(payload *RequestSongPayload) (*ingest.Song, error) {
+	if payload.ID != "" {
+		song := rc.dataService.GetSong(payload.ID)
+		if song == nil {
+			return nil, errSongNotFound
+		}
+		return song, nil
+	}
+
+	if payload.URL == "" {
+		return nil, errInvalidRequest
+	}
+
+	src, u, err := source.For(payload.URL)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported url: %w", err)
+	}
+
+	// Only the yt-dlp sites may be requested. The file and HTTP sources
+	// would let listeners read files off the server or make it fetch
+	// arbitrary URLs, including ones on its internal network.
+	switch src.(type) {
+	case *source.YouTube, *source.Ytdlp:
+	default:
+		return nil, fmt.Errorf("%w: %s", errSourceDenied, src.Name())
+	}
+
+	id, err := src.ID(u)
+	if err != nil {
+		return nil, fmt.Errorf("invalid url: %w", err)
+	}
+
+	if song := rc.dataService.GetSong(id); song != nil {
+		return song, nil
+	}
+
+	title := payload.Title
+	if title == "" {
+		title = payload.URL
+	}
+
+	return &ingest.Song{
+		Artist: payload.Artist,
+		Title:  title,
+		URL:    payload.URL,
+	}, nil
+}
+
+func requestErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errSongNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, picker.ErrRateLimited), errors.Is(err, picker.ErrTooManyPending):
+		return http.StatusTooManyRequests
+	case errors.Is(err, picker.ErrRequestQueueFull), errors.Is(err, picker.ErrAlreadyRequested):
+		return http.StatusConflict
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+func newRequestPayload(request *picker.Request, position int) *RequestPayload {
+	return &RequestPayload{
+		Song:        NewSongPayload(request.Song),
+		Name:        request.Name,
+		RequestedAt: request.RequestedAt.Format(time.RFC3339),
+		Position:    position,
+	}
+}
@@ -0,0 +1,166 @@
+package controller
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/feline-dis/go-radio/internal/stream"
+)
+
+// icyMetaInt is the number of audio bytes between ICY metadata blocks.
+const icyMetaInt = 16000
+
+// icyMaxMetadata is the largest metadata payload that fits in a single block.
+const icyMaxMetadata = 255 * 16
+
+type StreamController struct {
+	broadcaster *stream.Broadcaster
+}
+
+func NewStreamController(broadcaster *stream.Broadcaster) *StreamController {
+	return &StreamController{
+		broadcaster: broadcaster,
+	}
+}
+
+// RegisterRoutes serves the stream at path.
+func (sc *StreamController) RegisterRoutes(r *http.ServeMux, path string) {
+	r.HandleFunc(path, sc.getStream)
+	fmt.Println("stream routes registered")
+}
+
+func (sc *StreamController) getStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "audio/mpeg")
+	w.Header().Set("Cache-Control", "no-cache, no-store")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("icy-name", "go-radio")
+
+	var out io.Writer = w
+	if r.Header.Get("Icy-MetaData") == "1" {
+		w.Header().Set("icy-metaint", strconv.Itoa(icyMetaInt))
+		out = &icyWriter{
+			w:         w,
+			remaining: icyMetaInt,
+			title:     sc.currentTitle,
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	listener := sc.broadcaster.AddListener()
+	defer sc.broadcaster.RemoveListener(listener)
+
+	fmt.Println("stream listener connected:", r.RemoteAddr)
+	defer fmt.Println("stream listener disconnected:", r.RemoteAddr)
+
+	for {
+		select {
+		case data, ok := <-listener.C:
+			if !ok {
+				return
+			}
+			if _, err := out.Write(data); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (sc *StreamController) currentTitle() string {
+	if track := sc.broadcaster.Current(); track != nil {
+		return track.Title
+	}
+	return ""
+}
+
+// icyWriter interleaves SHOUTcast/ICY metadata blocks into the audio stream
+// every icyMetaInt bytes.
+type icyWriter struct {
+	w         io.Writer
+	remaining int
+	sentTitle string
+	sent      bool
+	title     func() string
+}
+
+func (iw *icyWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), iw.remaining)
+
+		m, err := iw.w.Write(p[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+
+		p = p[n:]
+		iw.remaining -= n
+
+		if iw.remaining == 0 {
+			if _, err := iw.w.Write(iw.metadata()); err != nil {
+				return written, err
+			}
+			iw.remaining = icyMetaInt
+		}
+	}
+
+	return written, nil
+}
+
+// metadata returns the next metadata block. The title is only repeated when it
+// changes; otherwise an empty block is sent.
+func (iw *icyWriter) metadata() []byte {
+	title := iw.title()
+	if iw.sent && title == iw.sentTitle {
+		return []byte{0}
+	}
+	iw.sent = true
+	iw.sentTitle = title
+
+	meta := "StreamTitle='" + icyTitle(title, icyMaxMetadata-len("StreamTitle='';")) + "';"
+
+	blocks := (len(meta) + 15) / 16
+	block := make([]byte, 1+blocks*16)
+	block[0] = byte(blocks)
+	copy(block[1:], meta)
+
+	return block
+}
+
+// icyReplacer removes the characters that delimit ICY metadata fields. Players
+// do not unescape, so quotes become typographic apostrophes.
+var icyReplacer = strings.NewReplacer("'", "’", ";", ",")
+
+// icyTitle makes title safe to quote in a StreamTitle field and shortens it to
+// at most maxLen bytes without splitting a character.
+func icyTitle(title string, maxLen int) string {
+	title = icyReplacer.Replace(title)
+	if len(title) <= maxLen {
+		return title
+	}
+
+	end := maxLen
+	for end > 0 && !utf8.RuneStart(title[end]) {
+		end--
+	}
+	return title[:end]
+}
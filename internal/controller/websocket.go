@@ -1,86 +1,441 @@
 package controller
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
 
 type MessageType string
 
+// Message types of the websocket protocol. protocol.json documents each of
+// them and their payloads.
 const (
-	MessageTypeConnect     MessageType = "connect"
+	MessageTypeHello   MessageType = "hello"
+	MessageTypeWelcome MessageType = "welcome"
+	MessageTypePing    MessageType = "ping"
+	MessageTypePong    MessageType = "pong"
+	MessageTypeAck     MessageType = "ack"
+	MessageTypeError   MessageType = "error"
+
 	MessageTypeCurrentSong MessageType = "current_song"
 	MessageTypeQueue       MessageType = "queue"
+
+	MessageTypeRequestSong     MessageType = "request_song"
+	MessageTypeRequestAccepted MessageType = "request_accepted"
+
+	MessageTypeVoteSkip  MessageType = "vote_skip"
+	MessageTypeSkipVotes MessageType = "skip_votes"
+
+	MessageTypeDownloadProgress MessageType = "download_progress"
 )
 
+// TimeFormat is RFC 3339 with millisecond precision, used for song start and
+// end times.
+const TimeFormat = "2006-01-02T15:04:05.000Z07:00"
+
 type CurrentSongPayload struct {
-	Artist    string `json:"artist"`
-	Title     string `json:"title"`
-	ArtUrl    string `json:"art_url"`
-	Duration  int    `json:"duration"`
+	Artist   string `json:"artist"`
+	Title    string `json:"title"`
+	Album    string `json:"album,omitempty"`
+	ArtUrl   string `json:"art_url"`
+	Duration int    `json:"duration"`
+	// DurationMs is the exact length of the song.
+	DurationMs int64 `json:"duration_ms"`
+	// GainDB is the gain applied to the stream to normalize the song's
+	// loudness. Clients playing the file directly can apply it themselves.
+	GainDB float64 `json:"gain_db"`
+	// CueInMs is where playback starts in the song's file. Clients playing
+	// the file directly seek to it plus the time elapsed since StartTime.
+	CueInMs   int64  `json:"cue_in_ms"`
 	StartTime string `json:"start_time"`
 	EndTime   string `json:"end_time"`
 	ID        string `json:"id"`
+	// Submitter is who added the song to the library.
+	Submitter *SubmitterPayload `json:"submitter,omitempty"`
+}
+
+// QueueEntryPayload is a song that is going to play. RequestedBy is the name
+// of the listener who requested it, if any.
+type QueueEntryPayload struct {
+	Song        *SongPayload `json:"song"`
+	RequestedBy string       `json:"requested_by,omitempty"`
+}
+
+// QueuePayload lists the songs that play next, in order.
+type QueuePayload struct {
+	Songs []*QueueEntryPayload `json:"songs"`
+}
+
+// VoteSkipPayload is sent by a client to vote to skip the current song. SongID
+// is optional and guards against votes arriving after the song changed.
+type VoteSkipPayload struct {
+	SongID string `json:"song_id"`
 }
 
+// SkipVotesPayload is the live tally of votes to skip the current song.
+type SkipVotesPayload struct {
+	SongID    string `json:"song_id"`
+	Votes     int    `json:"votes"`
+	Required  int    `json:"required"`
+	Listeners int    `json:"listeners"`
+	Skipped   bool   `json:"skipped"`
+}
+
+// Message is a message sent by the server. ReplyTo is the ID of the command it
+// answers, if any.
 type Message struct {
 	Type    MessageType `json:"type"`
+	ReplyTo string      `json:"reply_to,omitempty"`
 	Payload interface{} `json:"payload"`
 }
 
+// IncomingMessage is a command sent by a client. The payload is decoded by the
+// handler registered for its type. ID is chosen by the client and echoed in
+// the reply_to of the response.
+type IncomingMessage struct {
+	Type    MessageType     `json:"type"`
+	ID      string          `json:"id,omitempty"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// MessageHandler handles a command from a client. The returned message is sent
+// back as the response, or an ack if it is nil and the command has an ID. A
+// returned error is sent back as an error frame.
+type MessageHandler func(client *Client, payload json.RawMessage) (*Message, error)
+
+const (
+	// writeWait is how long a write to a client may take.
+	writeWait = 10 * time.Second
+	// pongWait is how long a client may stay silent before it is considered
+	// gone. Clients answer pings, so a healthy connection is never silent
+	// this long.
+	pongWait = 60 * time.Second
+	// pingPeriod is how often clients are pinged. It must be below pongWait.
+	pingPeriod = pongWait * 9 / 10
+	// maxMessageSize is the largest message a client may send.
+	maxMessageSize = 64 * 1024
+	// sendQueueSize is how many messages may wait to be written to a client.
+	sendQueueSize = 32
+	// maxDropped is how many messages in a row may be dropped for a client
+	// whose queue is full before it is disconnected.
+	maxDropped = 8
+)
+
+var (
+	errClientClosed = errors.New("client is disconnected")
+	errSlowClient   = errors.New("client is too slow, message dropped")
+)
+
+// Client is a single websocket connection. Messages to it are queued and
+// written by a dedicated goroutine, so a slow client never holds up others.
+type Client struct {
+	ID         uint64
+	RemoteAddr string
+	conn       *websocket.Conn
+	send       chan *Message
+	// dropped counts the messages in a row dropped because send was full.
+	dropped   atomic.Int32
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newClient(id uint64, remoteAddr string, conn *websocket.Conn) *Client {
+	return &Client{
+		ID:         id,
+		RemoteAddr: remoteAddr,
+		conn:       conn,
+		send:       make(chan *Message, sendQueueSize),
+		done:       make(chan struct{}),
+	}
+}
+
+// Send queues a message for the client. Messages are dropped while the
+// client's queue is full, and a client that keeps falling behind is
+// disconnected; it will reconnect and receive the current state.
+func (c *Client) Send(message *Message) error {
+	select {
+	case <-c.done:
+		return errClientClosed
+	default:
+	}
+
+	select {
+	case c.send <- message:
+		c.dropped.Store(0)
+		return nil
+	default:
+	}
+
+	if c.dropped.Add(1) >= maxDropped {
+		fmt.Printf("disconnecting slow websocket client %d\n", c.ID)
+		c.close()
+		return errClientClosed
+	}
+	return errSlowClient
+}
+
+// close disconnects the client. The write loop sends a close frame and closes
+// the connection, which ends the read loop, which unregisters the client.
+func (c *Client) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+	})
+}
+
+// writeLoop writes queued messages and pings to the connection until the
+// client is closed. It owns closing the connection, so the close frame is
+// written before the socket goes away.
+func (c *Client) writeLoop() {
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+	defer c.conn.Close()
+	defer c.close()
+
+	for {
+		select {
+		case message := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteJSON(message); err != nil {
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		case <-c.done:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+			return
+		}
+	}
+}
+
+// WebsocketController is a hub for websocket clients. The set of clients is
+// owned by the hub goroutine; clients join, leave and receive broadcasts
+// through its channels.
 type WebsocketController struct {
-	clients         map[*websocket.Conn]bool
-	sendOnNewClient *Message
+	// TrustedProxies are the proxies allowed to forward the address of a
+	// client. Clients are identified by their own address when empty.
+	TrustedProxies TrustedProxies
+	register       chan *Client
+	unregister     chan *Client
+	broadcast      chan *Message
+	clientCount    atomic.Int64
+	// addresses counts the connected clients per remote address.
+	addresses       map[string]int
+	handlers        map[MessageType]MessageHandler
+	sendOnNewClient []*Message
+	nextClientID    atomic.Uint64
+	mu              sync.RWMutex
 }
 
 var upgrader = websocket.Upgrader{}
 
 func NewWebsocketController() *WebsocketController {
-	return &WebsocketController{
-		clients: make(map[*websocket.Conn]bool),
+	wsc := &WebsocketController{
+		register:   make(chan *Client),
+		unregister: make(chan *Client),
+		broadcast:  make(chan *Message, 64),
+		handlers:   make(map[MessageType]MessageHandler),
+		addresses:  make(map[string]int),
 	}
+	wsc.handlers[MessageTypeHello] = wsc.handleHello
+	wsc.handlers[MessageTypePing] = wsc.handlePing
+	go wsc.run()
+	return wsc
 }
 
-func (wsc *WebsocketController) Upgrade(w http.ResponseWriter, r *http.Request) (*websocket.Conn, error) {
+// run owns the set of clients.
+func (wsc *WebsocketController) run() {
+	clients := make(map[*Client]bool)
+
+	for {
+		select {
+		case client := <-wsc.register:
+			clients[client] = true
+			wsc.clientCount.Store(int64(len(clients)))
+
+			wsc.mu.Lock()
+			wsc.addresses[client.RemoteAddr]++
+			sendOnNewClient := wsc.sendOnNewClient
+			wsc.mu.Unlock()
+
+			for _, message := range sendOnNewClient {
+				client.Send(message)
+			}
+
+		case client := <-wsc.unregister:
+			if clients[client] {
+				delete(clients, client)
+				wsc.clientCount.Store(int64(len(clients)))
+
+				wsc.mu.Lock()
+				if wsc.addresses[client.RemoteAddr]--; wsc.addresses[client.RemoteAddr] == 0 {
+					delete(wsc.addresses, client.RemoteAddr)
+				}
+				wsc.mu.Unlock()
+			}
+			client.close()
+
+		case message := <-wsc.broadcast:
+			for client := range clients {
+				client.Send(message)
+			}
+		}
+	}
+}
+
+func (wsc *WebsocketController) Upgrade(w http.ResponseWriter, r *http.Request) (*Client, error) {
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		return nil, err
 	}
-	wsc.clients[conn] = true
-	return conn, nil
+
+	client := newClient(wsc.nextClientID.Add(1), wsc.TrustedProxies.ClientIP(r), conn)
+	go client.writeLoop()
+	wsc.register <- client
+
+	return client, nil
+}
+
+// HandleMessage registers the handler for messages of the given type.
+func (wsc *WebsocketController) HandleMessage(messageType MessageType, handler MessageHandler) {
+	wsc.mu.Lock()
+	defer wsc.mu.Unlock()
+	wsc.handlers[messageType] = handler
 }
 
-func (wsc *WebsocketController) RegisterRoutes(r *http.ServeMux) {
-	r.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+// RegisterRoutes serves the websocket at path.
+func (wsc *WebsocketController) RegisterRoutes(r *http.ServeMux, path string) {
+	r.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
 		fmt.Println("websocket connection")
 
-		conn, err := wsc.Upgrade(w, r)
+		client, err := wsc.Upgrade(w, r)
 		if err != nil {
-			fmt.Fprintf(w, "%+v\n", err)
+			fmt.Printf("websocket upgrade failed: %v\n", err)
+			return
 		}
 
-		wsc.clients[conn] = true
-
-		if wsc.sendOnNewClient != nil {
-			conn.WriteJSON(wsc.sendOnNewClient)
-		}
+		wsc.readLoop(client)
 	})
 
 	fmt.Println("websocket routes registered")
 }
 
-func (wsc *WebsocketController) Broadcast(message *Message) {
-	for client := range wsc.clients {
-		err := client.WriteJSON(message)
-		if err != nil {
-			client.Close()
-			delete(wsc.clients, client)
+// readLoop dispatches messages from the client until its connection closes or
+// it stops answering pings.
+func (wsc *WebsocketController) readLoop(client *Client) {
+	defer func() {
+		wsc.unregister <- client
+	}()
+
+	client.conn.SetReadLimit(maxMessageSize)
+	client.conn.SetReadDeadline(time.Now().Add(pongWait))
+	client.conn.SetPongHandler(func(string) error {
+		return client.conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	for {
+		var message IncomingMessage
+		if err := client.conn.ReadJSON(&message); err != nil {
+			var (
+				syntaxErr *json.SyntaxError
+				typeErr   *json.UnmarshalTypeError
+			)
+			if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) {
+				client.Send(newErrorFrame(&ProtocolError{
+					Code:    ErrorCodeMalformedMessage,
+					Message: "malformed message",
+				}))
+				continue
+			}
+			return
 		}
+
+		// Any message shows the client is alive.
+		client.conn.SetReadDeadline(time.Now().Add(pongWait))
+
+		wsc.mu.RLock()
+		handler, exists := wsc.handlers[message.Type]
+		wsc.mu.RUnlock()
+
+		if !exists {
+			reply := newErrorFrame(&ProtocolError{
+				Code:    ErrorCodeUnknownType,
+				Message: fmt.Sprintf("unknown message type %q", message.Type),
+			})
+			reply.ReplyTo = message.ID
+			client.Send(reply)
+			continue
+		}
+
+		response, err := handler(client, message.Payload)
+		switch {
+		case err != nil:
+			response = newErrorFrame(err)
+		case response == nil && message.ID != "":
+			response = &Message{Type: MessageTypeAck}
+		case response == nil:
+			continue
+		}
+
+		response.ReplyTo = message.ID
+		client.Send(response)
 	}
 }
 
+// Broadcast queues a message for every connected client. It never waits on
+// the clients themselves.
+func (wsc *WebsocketController) Broadcast(message *Message) {
+	wsc.broadcast <- message
+}
+
+// ClientCount returns the number of connected websocket clients.
+func (wsc *WebsocketController) ClientCount() int {
+	return int(wsc.clientCount.Load())
+}
+
+// AddressCount returns the number of distinct addresses with a connected
+// client, i.e. the number of listeners however many tabs they have open.
+func (wsc *WebsocketController) AddressCount() int {
+	wsc.mu.RLock()
+	defer wsc.mu.RUnlock()
+	return len(wsc.addresses)
+}
+
+// IsConnected reports whether a client from address is connected.
+func (wsc *WebsocketController) IsConnected(address string) bool {
+	wsc.mu.RLock()
+	defer wsc.mu.RUnlock()
+	return wsc.addresses[address] > 0
+}
+
+// BroadcastOnNewClient sets a message every client receives when it connects,
+// replacing the previous message of the same type.
 func (wsc *WebsocketController) BroadcastOnNewClient(message *Message) {
-	wsc.sendOnNewClient =  message
+	wsc.mu.Lock()
+	defer wsc.mu.Unlock()
+
+	// Copy so the slice handed to the hub is never modified.
+	messages := make([]*Message, 0, len(wsc.sendOnNewClient)+1)
+	replaced := false
+	for _, existing := range wsc.sendOnNewClient {
+		if existing.Type == message.Type {
+			existing = message
+			replaced = true
+		}
+		messages = append(messages, existing)
+	}
+	if !replaced {
+		messages = append(messages, message)
+	}
+	wsc.sendOnNewClient = messages
 }
@@ -0,0 +1,104 @@
+package download
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/feline-dis/go-radio/internal/loudness"
+	"github.com/feline-dis/go-radio/internal/silence"
+	"github.com/feline-dis/go-radio/internal/source"
+)
+
+// analyzeLater measures the loudness and finds the cue points of a song that
+// is already playable, unless its metadata has them, and updates the song once
+// they are known. The song plays without a gain and untrimmed until then, so
+// the analysis never delays playback. At most numWorkers songs are analyzed at
+// once.
+func (ds *DownloadService) analyzeLater(id string, meta *source.Metadata, audioPath string, fileInfo os.FileInfo, duration time.Duration) {
+	if meta.Loudness != nil && meta.Cue != nil {
+		return
+	}
+
+	go func() {
+		select {
+		case ds.analysisSlots <- struct{}{}:
+			defer func() { <-ds.analysisSlots }()
+		case <-ds.ctx.Done():
+			return
+		}
+
+		if !ds.measure(meta, audioPath, duration) {
+			return
+		}
+
+		ds.setGain(meta)
+		if err := ds.saveMetadata(meta); err != nil {
+			fmt.Printf("failed to save metadata for %s: %v\n", id, err)
+		}
+
+		ds.mu.Lock()
+		defer ds.mu.Unlock()
+
+		// The song may have been evicted in the meantime.
+		if _, exists := ds.downloads[id]; exists {
+			ds.downloads[id] = newSongInfo(audioPath, fileInfo, duration, meta)
+		}
+	}()
+}
+
+// measure runs the loudness and silence filters meta still needs over the song
+// at audioPath in a single ffmpeg pass. It reports whether anything new was
+// measured.
+func (ds *DownloadService) measure(meta *source.Metadata, audioPath string, duration time.Duration) bool {
+	var filters []string
+	if meta.Cue == nil {
+		filters = append(filters, silence.Filter)
+	}
+	if meta.Loudness == nil {
+		filters = append(filters, loudness.Filter)
+	}
+
+	cmd := exec.CommandContext(ds.ctx, "ffmpeg",
+		"-hide_banner",
+		"-nostats",
+		"-i", audioPath,
+		"-af", strings.Join(filters, ","),
+		"-f", "null",
+		"-",
+	)
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		fmt.Printf("failed to analyze %s: %v\n", audioPath, err)
+		return false
+	}
+
+	measured := false
+	if meta.Loudness == nil {
+		measurement, err := loudness.Parse(output)
+		if err != nil {
+			fmt.Printf("failed to analyze loudness of %s: %v\n", audioPath, err)
+		} else {
+			meta.Loudness = measurement
+			measured = true
+		}
+	}
+	if meta.Cue == nil {
+		meta.Cue = silence.Parse(output, duration)
+		measured = true
+	}
+
+	return measured
+}
+
+// setGain sets the gain that brings the song to the loudness target. Songs
+// that were not measured are played without one.
+func (ds *DownloadService) setGain(meta *source.Metadata) {
+	meta.GainDB = 0
+	if meta.Loudness != nil {
+		meta.GainDB = meta.Loudness.Gain(ds.LoudnessTarget)
+	}
+}
@@ -0,0 +1,263 @@
+package download
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/feline-dis/go-radio/internal/library"
+)
+
+// PinnedSongs reports the IDs of songs that must stay in the cache, such as
+// the songs a station is playing or has queued.
+type PinnedSongs interface {
+	PinnedSongs() []string
+}
+
+// AddPinned registers songs that are never evicted from the cache.
+func (ds *DownloadService) AddPinned(pinned PinnedSongs) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	ds.pinned = append(ds.pinned, pinned)
+}
+
+// MarkPlayed records that a song started playing. Songs that have not played
+// for the longest time are evicted first. The time is kept as the modification
+// time of the song's metadata so it survives restarts.
+func (ds *DownloadService) MarkPlayed(id string) {
+	now := time.Now()
+
+	ds.mu.Lock()
+	ds.lastPlayed[id] = now
+	ds.mu.Unlock()
+
+	if err := os.Chtimes(ds.metadataPath(id), now, now); err != nil && !os.IsNotExist(err) {
+		fmt.Printf("failed to record play of %s: %v\n", id, err)
+	}
+}
+
+// LoadCache makes the songs downloaded by previous runs available again and
+// removes files left behind by downloads that did not finish. Other files are
+// kept. It must be called before the workers are started.
+func (ds *DownloadService) LoadCache() error {
+	entries, err := os.ReadDir(ds.CachePath)
+	if err != nil {
+		return fmt.Errorf("failed to read cache directory: %w", err)
+	}
+
+	names := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		names[entry.Name()] = true
+	}
+
+	restored := 0
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+
+		name := entry.Name()
+		ext := filepath.Ext(name)
+		id := strings.TrimSuffix(name, ext)
+
+		switch {
+		case name == unavailableFile:
+		case ext == ".json":
+			// Local MP3s play in place, so their metadata has no MP3 beside it.
+			if !names[id+".mp3"] && !strings.HasPrefix(id, library.IDPrefix) {
+				ds.removeLeftover(name)
+			}
+		case ext == ".mp3" && !strings.Contains(id, ".") && names[id+".json"]:
+			if err := ds.restore(id); err != nil {
+				fmt.Printf("failed to restore %s from the cache: %v\n", id, err)
+				continue
+			}
+			restored++
+		case ext == ".mp3" && strings.HasPrefix(id, library.IDPrefix):
+			// Transcoded local songs that were never analyzed are reused
+			// when they play.
+		case ext == ".mp3", leftoverExts[ext]:
+			// Metadata is written last, so a downloaded MP3 without it
+			// was interrupted too.
+			ds.removeLeftover(name)
+		}
+	}
+
+	fmt.Printf("restored %d songs from the cache\n", restored)
+	ds.evict()
+
+	return nil
+}
+
+// leftoverExts are the extensions of the partial and intermediate files
+// downloads leave behind when they are interrupted.
+var leftoverExts = map[string]bool{
+	".part": true,
+	".ytdl": true,
+	".temp": true,
+	".webm": true,
+	".m4a":  true,
+	".mp4":  true,
+	".opus": true,
+	".ogg":  true,
+	".aac":  true,
+	".flac": true,
+	".wav":  true,
+}
+
+// removeLeftover removes a file left behind by a download that did not finish.
+func (ds *DownloadService) removeLeftover(name string) {
+	fmt.Println("removing orphaned cache file", name)
+	if err := os.Remove(filepath.Join(ds.CachePath, name)); err != nil {
+		fmt.Printf("failed to remove %s: %v\n", name, err)
+	}
+}
+
+// restore adds a previously downloaded song to the downloads.
+func (ds *DownloadService) restore(id string) error {
+	audioPath := path.Join(ds.CachePath, id+".mp3")
+
+	fileInfo, err := os.Stat(audioPath)
+	if err != nil {
+		return fmt.Errorf("failed to stat file: %w", err)
+	}
+
+	meta, err := ds.loadMetadata(id)
+	if err != nil {
+		return err
+	}
+
+	metaInfo, err := os.Stat(ds.metadataPath(id))
+	if err != nil {
+		return fmt.Errorf("failed to stat metadata: %w", err)
+	}
+
+	// Apply the current loudness target to the stored measurement.
+	ds.setGain(meta)
+
+	duration := probeDuration(audioPath, meta.Duration)
+	info := newSongInfo(audioPath, fileInfo, duration, meta)
+
+	ds.mu.Lock()
+	ds.downloads[id] = info
+	ds.lastPlayed[id] = metaInfo.ModTime()
+	ds.mu.Unlock()
+
+	// Songs cached before they could be analyzed are measured now.
+	ds.analyzeLater(id, meta, audioPath, fileInfo, duration)
+
+	return nil
+}
+
+// evict removes the songs that were played longest ago from the cache until it
+// fits MaxCacheSize. Pinned songs and local files outside the cache are never
+// removed.
+func (ds *DownloadService) evict() {
+	if ds.MaxCacheSize <= 0 {
+		return
+	}
+
+	pinned := ds.pinnedSongs()
+
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	size, err := ds.cacheSize()
+	if err != nil {
+		fmt.Printf("failed to measure cache: %v\n", err)
+		return
+	}
+
+	if size <= ds.MaxCacheSize {
+		return
+	}
+
+	candidates := make([]string, 0, len(ds.downloads))
+	for id, info := range ds.downloads {
+		if !pinned[id] && ds.inCache(info.Path) {
+			candidates = append(candidates, id)
+		}
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return ds.lastPlayed[candidates[i]].Before(ds.lastPlayed[candidates[j]])
+	})
+
+	for _, id := range candidates {
+		if size <= ds.MaxCacheSize {
+			break
+		}
+
+		for _, file := range []string{ds.downloads[id].Path, ds.metadataPath(id)} {
+			stat, err := os.Stat(file)
+			if err != nil {
+				continue
+			}
+			if err := os.Remove(file); err != nil {
+				fmt.Printf("failed to evict %s: %v\n", file, err)
+				continue
+			}
+			size -= stat.Size()
+		}
+
+		delete(ds.downloads, id)
+		delete(ds.jobs, id)
+		delete(ds.lastPlayed, id)
+		fmt.Printf("evicted %s from the cache\n", id)
+	}
+
+	if size > ds.MaxCacheSize {
+		fmt.Printf("cache is %d bytes over its limit, the remaining songs are in use\n", size-ds.MaxCacheSize)
+	}
+}
+
+// pinnedSongs returns the IDs of all pinned songs.
+func (ds *DownloadService) pinnedSongs() map[string]bool {
+	ds.mu.RLock()
+	sources := append([]PinnedSongs(nil), ds.pinned...)
+	ds.mu.RUnlock()
+
+	pinned := make(map[string]bool)
+	for _, source := range sources {
+		for _, id := range source.PinnedSongs() {
+			pinned[id] = true
+		}
+	}
+	return pinned
+}
+
+// cacheSize returns the size of all files in the cache directory. Crossfades
+// in subdirectories are managed by the stations and not counted.
+func (ds *DownloadService) cacheSize() (int64, error) {
+	entries, err := os.ReadDir(ds.CachePath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read cache directory: %w", err)
+	}
+
+	var size int64
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		size += info.Size()
+	}
+
+	return size, nil
+}
+
+// inCache reports whether audioPath is a file in the cache directory.
+func (ds *DownloadService) inCache(audioPath string) bool {
+	return filepath.Clean(filepath.Dir(audioPath)) == filepath.Clean(ds.CachePath)
+}
+
+func (ds *DownloadService) metadataPath(id string) string {
+	return path.Join(ds.CachePath, id+".json")
+}
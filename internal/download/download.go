@@ -3,35 +3,69 @@ package download
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net/url"
 	"os"
-	"os/exec"
 	"path"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/feline-dis/go-radio/internal/events"
 	"github.com/feline-dis/go-radio/internal/ingest"
+	"github.com/feline-dis/go-radio/internal/loudness"
+	"github.com/feline-dis/go-radio/internal/mp3"
+	"github.com/feline-dis/go-radio/internal/source"
 )
 
-type YtdlpResponse struct {
-	ID          string `json:"id"`
-	Title       string `json:"title"`
-	Thumbnail   string `json:"thumbnail"`
-	Duration    int    `json:"duration"`
-	OriginalURL string `json:"original_url"`
-	Filename    string `json:"_filename"`
-}
-
 type SongInfo struct {
+	// Path is where the song's MP3 is stored.
+	Path     string
 	FileInfo os.FileInfo
-	Duration int
+	// Duration is the exact length of the MP3, to the millisecond.
+	Duration time.Duration
+	// Gain is the gain in dB that normalizes the song's loudness.
+	Gain float64
+	// CueIn and CueOut are where the song's audio starts and ends once
+	// leading and trailing silence are skipped.
+	CueIn  time.Duration
+	CueOut time.Duration
+}
+
+// PlayDuration returns how long the song plays between its cue points.
+func (info *SongInfo) PlayDuration() time.Duration {
+	return info.CueOut - info.CueIn
 }
 
+const (
+	// maxAttempts is how often a download is tried before it fails.
+	maxAttempts = 4
+	// retryBackoff is the wait before the first retry. It doubles with
+	// every further attempt.
+	retryBackoff = 2 * time.Second
+)
+
 type DownloadService struct {
-	CachePath     string
-	downloads     map[string]*SongInfo
-	downloadQueue chan *ingest.Song
-	numWorkers    int
+	CachePath string
+	// LoudnessTarget is the integrated loudness in LUFS songs are normalized to.
+	LoudnessTarget float64
+	// MaxCacheSize is the number of bytes the cache may use before the least
+	// recently played songs are evicted. The cache is unbounded when zero.
+	MaxCacheSize int64
+	// Events receives the progress of downloads. Nothing is published when
+	// it is nil.
+	Events              *events.Bus
+	downloads           map[string]*SongInfo
+	lastPlayed          map[string]time.Time
+	pinned              []PinnedSongs
+	unavailable         map[string]*UnavailableSong
+	unavailableHandlers []UnavailableHandler
+	jobs                map[string]*job
+	downloadQueue       chan *ingest.Song
+	numWorkers          int
+	// analysisSlots limits how many songs are analyzed at once.
+	analysisSlots chan struct{}
 	mu            sync.RWMutex
 	ctx           context.Context
 	cancel        context.CancelFunc
@@ -41,16 +75,24 @@ type DownloadService struct {
 func NewDownloadService(cachePath string, numWorkers int) *DownloadService {
 	ctx, cancel := context.WithCancel(context.Background())
 	ds := &DownloadService{
-		CachePath:     cachePath,
-		downloads:     make(map[string]*SongInfo),
-		downloadQueue: make(chan *ingest.Song, 100),
-		numWorkers:    numWorkers,
-		ctx:           ctx,
-		cancel:        cancel,
+		CachePath:      cachePath,
+		LoudnessTarget: loudness.DefaultTarget,
+		downloads:      make(map[string]*SongInfo),
+		lastPlayed:     make(map[string]time.Time),
+		unavailable:    make(map[string]*UnavailableSong),
+		jobs:           make(map[string]*job),
+		downloadQueue:  make(chan *ingest.Song, 100),
+		numWorkers:     numWorkers,
+		analysisSlots:  make(chan struct{}, max(numWorkers, 1)),
+		ctx:            ctx,
+		cancel:         cancel,
 	}
 	if err := ds.EnsureCacheDir(); err != nil {
 		panic(fmt.Sprintf("failed to create cache directory: %v", err))
 	}
+	if err := ds.loadUnavailable(); err != nil {
+		fmt.Printf("failed to load unavailable songs: %v\n", err)
+	}
 	return ds
 }
 
@@ -61,30 +103,6 @@ func (ds *DownloadService) EnsureCacheDir() error {
 	return nil
 }
 
-func (ds *DownloadService) EnsureDownloaded(song *ingest.Song) error {
-	if _, exists := ds.GetDownload(song.ID()); exists {
-		return nil
-	}
-
-	if err := ds.QueueDownload(song); err != nil {
-		fmt.Printf("Failed to queue download for %s: %v\n", song.URL, err)
-		return err
-	}
-
-	return nil
-}
-
-func (ds *DownloadService) QueueDownload(song *ingest.Song) error {
-	ds.activeJobs.Add(1) // Increment before queuing
-	select {
-	case ds.downloadQueue <- song:
-		return nil
-	case <-ds.ctx.Done():
-		ds.activeJobs.Done() // Decrement if we couldn't queue
-		return fmt.Errorf("download service is stopped")
-	}
-}
-
 func (ds *DownloadService) GetDownload(id string) (*SongInfo, bool) {
 	ds.mu.RLock()
 	defer ds.mu.RUnlock()
@@ -110,8 +128,14 @@ func (ds *DownloadService) worker(workerID int) {
 	for {
 		select {
 		case song := <-ds.downloadQueue:
-			if err := ds.downloadFile(song); err != nil {
-				fmt.Printf("Worker %d failed to download %s: %v\n", workerID, song, err)
+			ds.setState(song.ID(), StateDownloading)
+			err := ds.downloadWithRetry(song)
+			if err != nil {
+				fmt.Printf("Worker %d failed to download %s: %v\n", workerID, song.ID(), err)
+			}
+			ds.finish(song.ID(), err)
+			if err == nil {
+				ds.evict()
 			}
 			ds.activeJobs.Done() // Decrement when download is complete
 		case <-ds.ctx.Done():
@@ -121,103 +145,190 @@ func (ds *DownloadService) worker(workerID int) {
 	}
 }
 
+// downloadWithRetry downloads song, retrying with exponential backoff unless
+// the song is unavailable, in which case it is recorded as such.
+func (ds *DownloadService) downloadWithRetry(song *ingest.Song) error {
+	if ds.IsUnavailable(song.ID()) {
+		return fmt.Errorf("%w: failed permanently before", source.ErrUnavailable)
+	}
+
+	backoff := retryBackoff
+	for attempt := 1; ; attempt++ {
+		err := ds.downloadFile(song)
+		if err == nil {
+			return nil
+		}
+
+		if errors.Is(err, source.ErrUnavailable) {
+			ds.markUnavailable(song, err)
+			return err
+		}
+
+		if attempt == maxAttempts {
+			return fmt.Errorf("giving up after %d attempts: %w", attempt, err)
+		}
+
+		fmt.Printf("download of %s failed, retrying in %v: %v\n", song.ID(), backoff, err)
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-ds.ctx.Done():
+			return err
+		}
+	}
+}
+
 func (ds *DownloadService) downloadFile(song *ingest.Song) error {
-	if ds.downloads[song.ID()] != nil {
+	if _, exists := ds.GetDownload(song.ID()); exists {
 		return nil
 	}
+
+	if song.IsLocal() {
+		return ds.loadLocal(song)
+	}
+
+	audioPath := path.Join(ds.CachePath, song.ID()+".mp3")
+
 	// check if file already exists in filesystem by ID before downloading
-	var ytdlpResponse *YtdlpResponse
-	var fileInfo os.FileInfo
-	if info, err := os.Stat(path.Join(ds.CachePath, song.ID()+".mp3")); err == nil {
-		meta, err := os.ReadFile(path.Join(ds.CachePath, song.ID()+".json"))
+	var meta *source.Metadata
+	fileInfo, err := os.Stat(audioPath)
+	if err == nil {
+		meta, err = ds.loadMetadata(song.ID())
 		if err != nil {
-			// file exists but metadata does not, download it
-			ytdlpResponse, err = ds.downloadJSON(song.URL)
+			// file exists but metadata does not, look it up
+			meta, err = source.Lookup(ds.ctx, song.URL)
+			if err != nil {
+				return fmt.Errorf("failed to fetch metadata: %w", err)
+			}
 		}
-		if err := json.Unmarshal(meta, &ytdlpResponse); err != nil {
-			fmt.Println(string(meta))
-			return fmt.Errorf("failed to parse metadata: %w", err)
+		if meta.Duration == 0 {
+			if meta.Duration, err = source.ProbeDuration(audioPath); err != nil {
+				return fmt.Errorf("failed to read duration: %w", err)
+			}
 		}
-		fileInfo = info
 	} else {
 		// file does not exist, download it
-		ytdlpResponse, err = ds.downloadAudio(song.URL)
+		ctx := source.WithProgress(ds.ctx, func(p source.Progress) {
+			ds.updateProgress(song.ID(), p)
+		})
+		meta, err = source.Fetch(ctx, song.URL, audioPath)
 		if err != nil {
 			return fmt.Errorf("failed to download: %w", err)
 		}
 
-		fileInfo, err = os.Stat(path.Join(ds.CachePath, ytdlpResponse.ID+".mp3"))
+		fileInfo, err = os.Stat(audioPath)
 		if err != nil {
 			return fmt.Errorf("failed to stat file: %w", err)
 		}
 	}
 
-	ds.saveMetadata(ytdlpResponse)
+	meta.ID = song.ID()
+	duration := probeDuration(audioPath, meta.Duration)
+	ds.setGain(meta)
+	if err := ds.saveMetadata(meta); err != nil {
+		fmt.Printf("failed to save metadata for %s: %v\n", song.ID(), err)
+	}
 
 	ds.mu.Lock()
-	ds.downloads[song.ID()] = &SongInfo{
-		FileInfo: fileInfo,
-		Duration: ytdlpResponse.Duration,
-	}
+	ds.downloads[song.ID()] = newSongInfo(audioPath, fileInfo, duration, meta)
+	ds.lastPlayed[song.ID()] = time.Now()
 	ds.mu.Unlock()
 
+	ds.analyzeLater(song.ID(), meta, audioPath, fileInfo, duration)
+
 	return nil
 }
 
-func (ds *DownloadService) downloadAudio(url string) (*YtdlpResponse, error) {
-	args := []string{
-		"-x",
-		"--audio-format",
-		"mp3",
-		"--print-json",
-		"-o",
-		ds.CachePath + "/%(id)s.%(ext)s",
-		url,
+// loadLocal makes a song from a local library playable. MP3s are played where
+// they are; other formats are transcoded into the cache.
+func (ds *DownloadService) loadLocal(song *ingest.Song) error {
+	audioPath := song.Path
+	if !strings.EqualFold(path.Ext(song.Path), ".mp3") {
+		audioPath = path.Join(ds.CachePath, song.ID()+".mp3")
+		if _, err := os.Stat(audioPath); err != nil {
+			// Build the URL directly, file names may contain '#' or '?'.
+			if _, err := source.NewFile().Fetch(ds.ctx, &url.URL{Path: song.Path}, audioPath); err != nil {
+				return fmt.Errorf("failed to transcode: %w", err)
+			}
+		}
 	}
 
-	cmd := exec.Command("yt-dlp", args...)
-
-	stdout, err := cmd.Output()
+	fileInfo, err := os.Stat(audioPath)
+	if err != nil {
+		return fmt.Errorf("failed to stat file: %w", err)
+	}
 
+	// Local songs keep a sidecar in the cache for their analysis.
+	meta, err := ds.loadMetadata(song.ID())
 	if err != nil {
-		return nil, fmt.Errorf("yt-dlp failed: %w", err)
+		meta = &source.Metadata{
+			ID:     song.ID(),
+			Title:  song.Title,
+			Artist: song.Artist,
+		}
 	}
 
-	return ds.parsdeYtdlpResponse(stdout)
+	duration := probeDuration(audioPath, song.Duration)
+	ds.setGain(meta)
+
+	ds.mu.Lock()
+	ds.downloads[song.ID()] = newSongInfo(audioPath, fileInfo, duration, meta)
+	ds.lastPlayed[song.ID()] = time.Now()
+	ds.mu.Unlock()
+
+	ds.analyzeLater(song.ID(), meta, audioPath, fileInfo, duration)
+
+	return nil
 }
 
-func (ds *DownloadService) parsdeYtdlpResponse(stdout []byte) (*YtdlpResponse, error) {
-	var ytdlpResponse YtdlpResponse
+func newSongInfo(audioPath string, fileInfo os.FileInfo, duration time.Duration, meta *source.Metadata) *SongInfo {
+	info := &SongInfo{
+		Path:     audioPath,
+		FileInfo: fileInfo,
+		Duration: duration,
+		Gain:     meta.GainDB,
+		CueOut:   duration,
+	}
 
-	if err := json.Unmarshal(stdout, &ytdlpResponse); err != nil {
-		return nil, fmt.Errorf("failed to parse yt-dlp response: %w", err)
+	// Ignore cue points that do not fit the file, e.g. if it was replaced.
+	if cue := meta.Cue; cue != nil && cue.OutDuration() <= duration && cue.InDuration() < cue.OutDuration() {
+		info.CueIn = cue.InDuration()
+		info.CueOut = cue.OutDuration()
 	}
 
-	return &ytdlpResponse, nil
+	return info
 }
 
-func (ds *DownloadService) downloadJSON(url string) (*YtdlpResponse, error) {
-	args := []string{
-		"--skip-download",
-		"-j",
-		url,
+// probeDuration measures the MP3 at audioPath, falling back to the duration in
+// seconds reported by its source if the file cannot be parsed.
+func probeDuration(audioPath string, reported int) time.Duration {
+	duration, err := mp3.FileDuration(audioPath)
+	if err != nil || duration == 0 {
+		fmt.Printf("failed to measure %s, using reported duration: %v\n", audioPath, err)
+		return time.Duration(reported) * time.Second
 	}
 
-	cmd := exec.Command("yt-dlp", args...)
-	fmt.Printf("yt-dlp %v\n", strings.Join(args, " "))
-	stdout, err := cmd.Output()
+	return duration.Round(time.Millisecond)
+}
 
+func (ds *DownloadService) loadMetadata(id string) (*source.Metadata, error) {
+	data, err := os.ReadFile(ds.metadataPath(id))
 	if err != nil {
-		return nil, fmt.Errorf("yt-dlp failed: %w", err)
+		return nil, fmt.Errorf("failed to read metadata: %w", err)
+	}
+
+	var meta source.Metadata
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, fmt.Errorf("failed to parse metadata: %w", err)
 	}
 
-	return ds.parsdeYtdlpResponse(stdout)
+	return &meta, nil
 }
 
-func (ds *DownloadService) saveMetadata(ytdlpResponse *YtdlpResponse) error {
-	json, err := json.Marshal(ytdlpResponse)
+func (ds *DownloadService) saveMetadata(meta *source.Metadata) error {
+	json, err := json.Marshal(meta)
 	if err != nil {
 		return fmt.Errorf("failed to marshal metadata: %w", err)
 	}
-	return os.WriteFile(path.Join(ds.CachePath, ytdlpResponse.ID+".json"), json, 0644)
+	return os.WriteFile(ds.metadataPath(meta.ID), json, 0644)
 }
@@ -0,0 +1,247 @@
+package download
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/feline-dis/go-radio/internal/events"
+	"github.com/feline-dis/go-radio/internal/ingest"
+	"github.com/feline-dis/go-radio/internal/source"
+)
+
+// TopicProgress is the topic a Progress is published on whenever a download
+// changes state or advances.
+const TopicProgress events.Topic = "download.progress"
+
+// progressInterval is the least time between two progress events of a
+// download. State changes are always published.
+const progressInterval = 250 * time.Millisecond
+
+// State is where a song is in the download pipeline.
+type State int
+
+const (
+	// StateNone is the state of songs that were never queued, or were
+	// evicted from the cache.
+	StateNone State = iota
+	StateQueued
+	StateDownloading
+	StateDone
+	StateFailed
+)
+
+func (s State) String() string {
+	switch s {
+	case StateQueued:
+		return "queued"
+	case StateDownloading:
+		return "downloading"
+	case StateDone:
+		return "done"
+	case StateFailed:
+		return "failed"
+	default:
+		return "none"
+	}
+}
+
+// job is a single attempt at downloading a song. Every caller asking for the
+// song while the job is queued or downloading shares it, so a song is never
+// fetched twice at once. done is closed when the job finishes.
+type job struct {
+	state     State
+	done      chan struct{}
+	err       error
+	progress  source.Progress
+	published time.Time
+}
+
+// Progress is the state of a song's download.
+type Progress struct {
+	ID    string
+	State State
+	source.Progress
+}
+
+// EnsureDownloaded queues song unless it is downloaded, queued or downloading.
+func (ds *DownloadService) EnsureDownloaded(song *ingest.Song) error {
+	if err := ds.QueueDownload(song); err != nil {
+		fmt.Printf("Failed to queue download for %s: %v\n", song.URL, err)
+		return err
+	}
+
+	return nil
+}
+
+// QueueDownload queues song for download. It does nothing if the song is
+// already downloaded or a download of it is queued or in progress; songs whose
+// last download failed are queued again.
+func (ds *DownloadService) QueueDownload(song *ingest.Song) error {
+	id := song.ID()
+
+	ds.mu.Lock()
+	if _, exists := ds.downloads[id]; exists {
+		ds.mu.Unlock()
+		return nil
+	}
+	if j, exists := ds.jobs[id]; exists && j.state != StateFailed {
+		ds.mu.Unlock()
+		return nil
+	}
+	ds.jobs[id] = &job{state: StateQueued, done: make(chan struct{})}
+	ds.mu.Unlock()
+	ds.publishProgress(id)
+
+	ds.activeJobs.Add(1) // Increment before queuing
+	select {
+	case ds.downloadQueue <- song:
+		return nil
+	case <-ds.ctx.Done():
+		err := fmt.Errorf("download service is stopped")
+		ds.finish(id, err)
+		ds.activeJobs.Done() // Decrement if we couldn't queue
+		return err
+	}
+}
+
+// WaitForDownload blocks until the song with the given ID has finished
+// downloading, its download failed, or ctx is done. It fails immediately for
+// songs that were never queued.
+func (ds *DownloadService) WaitForDownload(ctx context.Context, id string) (*SongInfo, error) {
+	ds.mu.RLock()
+	info, exists := ds.downloads[id]
+	j := ds.jobs[id]
+	ds.mu.RUnlock()
+
+	if exists {
+		return info, nil
+	}
+	if j == nil {
+		return nil, fmt.Errorf("song %s is not queued for download", id)
+	}
+
+	select {
+	case <-j.done:
+		if j.err != nil {
+			return nil, fmt.Errorf("download of song %s failed: %w", id, j.err)
+		}
+		info, exists := ds.GetDownload(id)
+		if !exists {
+			return nil, fmt.Errorf("download of song %s missing after completion", id)
+		}
+		return info, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// State returns where the song with the given ID is in the download pipeline.
+func (ds *DownloadService) State(id string) State {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+
+	if _, exists := ds.downloads[id]; exists {
+		return StateDone
+	}
+	if j, exists := ds.jobs[id]; exists {
+		return j.state
+	}
+	return StateNone
+}
+
+// IsDownloading reports whether a download for the song is queued or in progress.
+func (ds *DownloadService) IsDownloading(id string) bool {
+	state := ds.State(id)
+	return state == StateQueued || state == StateDownloading
+}
+
+// Jobs returns the downloads that are queued, in progress or failed.
+func (ds *DownloadService) Jobs() []Progress {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+
+	jobs := make([]Progress, 0)
+	for id, j := range ds.jobs {
+		if j.state != StateDone {
+			jobs = append(jobs, Progress{ID: id, State: j.state, Progress: j.progress})
+		}
+	}
+
+	sort.Slice(jobs, func(i, j int) bool {
+		if jobs[i].State != jobs[j].State {
+			return jobs[i].State < jobs[j].State
+		}
+		return jobs[i].ID < jobs[j].ID
+	})
+
+	return jobs
+}
+
+func (ds *DownloadService) setState(id string, state State) {
+	ds.mu.Lock()
+	if j, exists := ds.jobs[id]; exists {
+		j.state = state
+	}
+	ds.mu.Unlock()
+
+	ds.publishProgress(id)
+}
+
+// updateProgress records how far the song's download has got and publishes it
+// at most every progressInterval.
+func (ds *DownloadService) updateProgress(id string, p source.Progress) {
+	ds.mu.Lock()
+	j, exists := ds.jobs[id]
+	if !exists {
+		ds.mu.Unlock()
+		return
+	}
+	j.progress = p
+	throttled := time.Since(j.published) < progressInterval && p.Downloaded < p.Total
+	ds.mu.Unlock()
+
+	if !throttled {
+		ds.publishProgress(id)
+	}
+}
+
+// publishProgress publishes the current state of the song's download.
+func (ds *DownloadService) publishProgress(id string) {
+	if ds.Events == nil {
+		return
+	}
+
+	ds.mu.Lock()
+	j, exists := ds.jobs[id]
+	if !exists {
+		ds.mu.Unlock()
+		return
+	}
+	j.published = time.Now()
+	progress := Progress{ID: id, State: j.state, Progress: j.progress}
+	ds.mu.Unlock()
+
+	ds.Events.Publish(TopicProgress, progress)
+}
+
+// finish completes the song's job and wakes everyone waiting on it.
+func (ds *DownloadService) finish(id string, err error) {
+	ds.mu.Lock()
+	j, exists := ds.jobs[id]
+	if !exists {
+		ds.mu.Unlock()
+		return
+	}
+
+	j.err = err
+	j.state = StateDone
+	if err != nil {
+		j.state = StateFailed
+	}
+	close(j.done)
+	ds.mu.Unlock()
+
+	ds.publishProgress(id)
+}
@@ -0,0 +1,110 @@
+package download
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/feline-dis/go-radio/internal/ingest"
+)
+
+// unavailableFile lists the songs that can no longer be downloaded. It is
+// kept in the cache so clearing the cache gives every song another chance.
+const unavailableFile = "unavailable.json"
+
+// UnavailableSong is a song that failed to download with a permanent error.
+type UnavailableSong struct {
+	ID     string    `json:"id"`
+	URL    string    `json:"url"`
+	Reason string    `json:"reason"`
+	Since  time.Time `json:"since"`
+}
+
+// UnavailableHandler is called when a song is found to be unavailable.
+type UnavailableHandler func(song *ingest.Song, reason error)
+
+// OnUnavailable registers a handler that is called whenever a song is marked
+// unavailable.
+func (ds *DownloadService) OnUnavailable(handler UnavailableHandler) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	ds.unavailableHandlers = append(ds.unavailableHandlers, handler)
+}
+
+// IsUnavailable reports whether the song with the given ID can no longer be
+// downloaded.
+func (ds *DownloadService) IsUnavailable(id string) bool {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+	_, exists := ds.unavailable[id]
+	return exists
+}
+
+// markUnavailable records that song failed permanently and tells the handlers.
+func (ds *DownloadService) markUnavailable(song *ingest.Song, reason error) {
+	ds.mu.Lock()
+	ds.unavailable[song.ID()] = &UnavailableSong{
+		ID:     song.ID(),
+		URL:    song.URL,
+		Reason: reason.Error(),
+		Since:  time.Now(),
+	}
+	err := ds.saveUnavailable()
+	handlers := append([]UnavailableHandler(nil), ds.unavailableHandlers...)
+	ds.mu.Unlock()
+
+	if err != nil {
+		fmt.Printf("failed to save unavailable songs: %v\n", err)
+	}
+
+	fmt.Printf("song %s is unavailable: %v\n", song.ID(), reason)
+	for _, handler := range handlers {
+		handler(song, reason)
+	}
+}
+
+// loadUnavailable reads the unavailable songs recorded by previous runs.
+func (ds *DownloadService) loadUnavailable() error {
+	data, err := os.ReadFile(path.Join(ds.CachePath, unavailableFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read unavailable songs: %w", err)
+	}
+
+	var songs []*UnavailableSong
+	if err := json.Unmarshal(data, &songs); err != nil {
+		return fmt.Errorf("failed to parse unavailable songs: %w", err)
+	}
+
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	for _, song := range songs {
+		ds.unavailable[song.ID] = song
+	}
+
+	return nil
+}
+
+// saveUnavailable writes the unavailable songs. The caller must hold mu.
+func (ds *DownloadService) saveUnavailable() error {
+	songs := make([]*UnavailableSong, 0, len(ds.unavailable))
+	for _, song := range ds.unavailable {
+		songs = append(songs, song)
+	}
+	sort.Slice(songs, func(i, j int) bool {
+		return songs[i].Since.Before(songs[j].Since)
+	})
+
+	data, err := json.MarshalIndent(songs, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal unavailable songs: %w", err)
+	}
+
+	return os.WriteFile(path.Join(ds.CachePath, unavailableFile), data, 0644)
+}
@@ -0,0 +1,83 @@
+package events
+
+import (
+	"sync"
+)
+
+// Topic names a kind of event.
+type Topic string
+
+// Event is something that happened in one part of the server that others may
+// react to.
+type Event struct {
+	Topic   Topic
+	Payload interface{}
+}
+
+// Handler is called with every event published on the topics it was
+// subscribed to. Handlers run on the publisher's goroutine and must not block.
+type Handler func(Event)
+
+type subscription struct {
+	topic   Topic
+	handler Handler
+}
+
+// Bus delivers events to subscribers.
+type Bus struct {
+	subscriptions map[uint64]*subscription
+	nextID        uint64
+	mu            sync.RWMutex
+}
+
+// NewBus creates an event bus without subscribers.
+func NewBus() *Bus {
+	return &Bus{
+		subscriptions: make(map[uint64]*subscription),
+	}
+}
+
+// Subscribe calls handler for every event published on topic until the
+// returned function is called.
+func (b *Bus) Subscribe(topic Topic, handler Handler) (unsubscribe func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.nextID++
+	id := b.nextID
+	b.subscriptions[id] = &subscription{
+		topic:   topic,
+		handler: handler,
+	}
+
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subscriptions, id)
+	}
+}
+
+// Publish delivers an event to the subscribers of topic. Publishing on a nil
+// bus does nothing.
+func (b *Bus) Publish(topic Topic, payload interface{}) {
+	if b == nil {
+		return
+	}
+
+	b.mu.RLock()
+	handlers := make([]Handler, 0, len(b.subscriptions))
+	for _, sub := range b.subscriptions {
+		if sub.topic == topic {
+			handlers = append(handlers, sub.handler)
+		}
+	}
+	b.mu.RUnlock()
+
+	event := Event{
+		Topic:   topic,
+		Payload: payload,
+	}
+	for _, handler := range handlers {
+		handler(event)
+	}
+}
@@ -0,0 +1,252 @@
+package history
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// migrations are applied in order; the index of the last applied migration is
+// stored in the database's user_version.
+var migrations = []string{
+	`CREATE TABLE plays (
+		id         INTEGER PRIMARY KEY AUTOINCREMENT,
+		song_id    TEXT    NOT NULL,
+		artist     TEXT    NOT NULL,
+		title      TEXT    NOT NULL,
+		submitter  TEXT    NOT NULL,
+		start_time INTEGER NOT NULL,
+		end_time   INTEGER NOT NULL,
+		listeners  INTEGER NOT NULL,
+		skipped    INTEGER NOT NULL
+	);
+	CREATE INDEX plays_start_time ON plays (start_time);
+	CREATE INDEX plays_song_id ON plays (song_id);
+	CREATE INDEX plays_submitter ON plays (submitter);`,
+	`ALTER TABLE plays ADD COLUMN station TEXT NOT NULL DEFAULT '';
+	CREATE INDEX plays_station_start_time ON plays (station, start_time);`,
+}
+
+// Play is a single song that went to air.
+type Play struct {
+	ID        int64
+	Station   string
+	SongID    string
+	Artist    string
+	Title     string
+	Submitter string
+	StartTime time.Time
+	EndTime   time.Time
+	Listeners int
+	Skipped   bool
+}
+
+// SongPlays is the number of times a song was played.
+type SongPlays struct {
+	SongID string
+	Artist string
+	Title  string
+	Plays  int
+}
+
+// SubmitterPlays is the number of times songs from a submitter were played.
+type SubmitterPlays struct {
+	Submitter string
+	Plays     int
+}
+
+// Store persists play history in an embedded SQLite database.
+type Store struct {
+	db *sql.DB
+}
+
+// NewStore opens the database at path, creating and migrating it as needed.
+func NewStore(path string) (*Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create database directory: %w", err)
+	}
+
+	db, err := sql.Open("sqlite", path+"?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	// SQLite only allows a single writer.
+	db.SetMaxOpenConns(1)
+
+	store := &Store{db: db}
+	if err := store.migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// Close closes the database.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+func (s *Store) migrate() error {
+	var version int
+	if err := s.db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+
+	for i := version; i < len(migrations); i++ {
+		tx, err := s.db.Begin()
+		if err != nil {
+			return fmt.Errorf("failed to begin migration %d: %w", i+1, err)
+		}
+
+		if _, err := tx.Exec(migrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to apply migration %d: %w", i+1, err)
+		}
+
+		// PRAGMA does not support placeholders.
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", i+1)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to update schema version: %w", err)
+		}
+
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to commit migration %d: %w", i+1, err)
+		}
+	}
+
+	return nil
+}
+
+// RecordPlay stores a finished play and sets its ID.
+func (s *Store) RecordPlay(play *Play) error {
+	result, err := s.db.Exec(
+		`INSERT INTO plays (station, song_id, artist, title, submitter, start_time, end_time, listeners, skipped)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		play.Station,
+		play.SongID,
+		play.Artist,
+		play.Title,
+		play.Submitter,
+		play.StartTime.UnixMilli(),
+		play.EndTime.UnixMilli(),
+		play.Listeners,
+		play.Skipped,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record play: %w", err)
+	}
+
+	play.ID, err = result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to read play id: %w", err)
+	}
+
+	return nil
+}
+
+// Recent returns the last limit plays on a station, most recent first.
+func (s *Store) Recent(station string, limit int) ([]*Play, error) {
+	rows, err := s.db.Query(
+		`SELECT id, station, song_id, artist, title, submitter, start_time, end_time, listeners, skipped
+		FROM plays
+		WHERE station = ?
+		ORDER BY start_time DESC, id DESC
+		LIMIT ?`,
+		station,
+		limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query plays: %w", err)
+	}
+	defer rows.Close()
+
+	plays := make([]*Play, 0, limit)
+	for rows.Next() {
+		var (
+			play      Play
+			startTime int64
+			endTime   int64
+		)
+
+		if err := rows.Scan(&play.ID, &play.Station, &play.SongID, &play.Artist, &play.Title, &play.Submitter, &startTime, &endTime, &play.Listeners, &play.Skipped); err != nil {
+			return nil, fmt.Errorf("failed to scan play: %w", err)
+		}
+
+		play.StartTime = time.UnixMilli(startTime)
+		play.EndTime = time.UnixMilli(endTime)
+		plays = append(plays, &play)
+	}
+
+	return plays, rows.Err()
+}
+
+// PlaysPerSong returns the most played songs on a station since the given
+// time. An empty station counts plays across all stations.
+func (s *Store) PlaysPerSong(station string, since time.Time, limit int) ([]*SongPlays, error) {
+	rows, err := s.db.Query(
+		`SELECT song_id, MAX(artist), MAX(title), COUNT(*) AS plays
+		FROM plays
+		WHERE start_time >= ? AND (? = '' OR station = ?)
+		GROUP BY song_id
+		ORDER BY plays DESC, MAX(start_time) DESC
+		LIMIT ?`,
+		since.UnixMilli(),
+		station,
+		station,
+		limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query plays per song: %w", err)
+	}
+	defer rows.Close()
+
+	counts := make([]*SongPlays, 0, limit)
+	for rows.Next() {
+		var count SongPlays
+		if err := rows.Scan(&count.SongID, &count.Artist, &count.Title, &count.Plays); err != nil {
+			return nil, fmt.Errorf("failed to scan plays per song: %w", err)
+		}
+		counts = append(counts, &count)
+	}
+
+	return counts, rows.Err()
+}
+
+// PlaysPerSubmitter returns the submitters whose songs were played most on a
+// station since the given time. An empty station counts plays across all
+// stations.
+func (s *Store) PlaysPerSubmitter(station string, since time.Time, limit int) ([]*SubmitterPlays, error) {
+	rows, err := s.db.Query(
+		`SELECT submitter, COUNT(*) AS plays
+		FROM plays
+		WHERE start_time >= ? AND (? = '' OR station = ?)
+		GROUP BY submitter
+		ORDER BY plays DESC, submitter
+		LIMIT ?`,
+		since.UnixMilli(),
+		station,
+		station,
+		limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query plays per submitter: %w", err)
+	}
+	defer rows.Close()
+
+	counts := make([]*SubmitterPlays, 0, limit)
+	for rows.Next() {
+		var count SubmitterPlays
+		if err := rows.Scan(&count.Submitter, &count.Plays); err != nil {
+			return nil, fmt.Errorf("failed to scan plays per submitter: %w", err)
+		}
+		counts = append(counts, &count)
+	}
+
+	return counts, rows.Err()
+}
@@ -6,9 +6,12 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"sync"
+	"time"
 
-	"github.com/feline-dis/go-radio/internal/utils"
+	"github.com/feline-dis/go-radio/internal/library"
+	"github.com/feline-dis/go-radio/internal/source"
 )
 
 // DataService ingests songs and submitters from JSON files in the configured ingestPath.
@@ -16,7 +19,12 @@ type DataService struct {
 	Submitters     map[string]Submitter
 	submittersLock sync.RWMutex
 	Songs          []*Song
+	songIndex      map[string]*Song
+	files          map[string]*ingestedFile
 	songsLock      sync.RWMutex
+	scanner        *library.Scanner
+	changeHandlers []ChangeHandler
+	handlersLock   sync.Mutex
 	ingestPath     string
 	workQueue      chan string
 	numWorkers     int
@@ -25,6 +33,21 @@ type DataService struct {
 	activeJobs     sync.WaitGroup
 }
 
+// ingestedFile is the state of a song list when it was last ingested.
+type ingestedFile struct {
+	modTime   time.Time
+	size      int64
+	submitter Submitter
+	songs     []*Song
+	// local is set when the file references a local library, which is
+	// rescanned even if the file itself is unchanged.
+	local bool
+}
+
+// ChangeHandler is called after a reload with the songs that were added to and
+// removed from the library.
+type ChangeHandler func(added, removed []*Song)
+
 // Submitter represents a submitter of songs.
 type Submitter struct {
 	Name string `json:"name"`
@@ -35,13 +58,30 @@ type Submitter struct {
 type Song struct {
 	Artist string `json:"artist"`
 	Title  string `json:"title"`
+	Album  string `json:"album,omitempty"`
 	ArtUrl string `json:"art_url"`
 	URL    string `json:"url"`
+	// Path is a local audio file, or a directory of them, to play instead of
+	// downloading URL.
+	Path string `json:"path,omitempty"`
+	// Duration is the length of a local song in seconds.
+	Duration int `json:"-"`
+	// Submitter is who added the song to the library. It is nil for songs
+	// that were requested from outside the library.
+	Submitter *Submitter `json:"-"`
+
+	// id is the content hash of a local song.
+	id string
 }
 
-// ID returns the YouTube video ID of the song or "" if an error is encountered.
+// ID returns the content hash of a local song, or the ID the song's source
+// gives it or "" if no source supports its URL.
 func (s Song) ID() string {
-	id, err := utils.ParseYouTubeVideoID(s.URL)
+	if s.id != "" {
+		return s.id
+	}
+
+	id, err := source.ID(s.URL)
 
 	if err != nil {
 		return ""
@@ -55,6 +95,8 @@ type SongList struct {
 	Name  string  `json:"name"`
 	Pfp   string  `json:"pfp_url"`
 	Songs []*Song `json:"songs"`
+	// Library is a local directory whose tracks are all added to the list.
+	Library string `json:"library"`
 }
 
 // NewDataService creates a new data service.
@@ -63,6 +105,9 @@ func NewDataService(ingestPath string, numWorkers int) *DataService {
 	return &DataService{
 		Submitters: make(map[string]Submitter),
 		Songs:      make([]*Song, 0),
+		songIndex:  make(map[string]*Song),
+		files:      make(map[string]*ingestedFile),
+		scanner:    library.NewScanner(),
 		ingestPath: ingestPath,
 		workQueue:  make(chan string, 100),
 		numWorkers: numWorkers,
@@ -71,25 +116,27 @@ func NewDataService(ingestPath string, numWorkers int) *DataService {
 	}
 }
 
-// Ingest reads all JSON files in the ingest path and adds them to the data service.
+// Ingest reads all JSON files in the ingest path that are new or changed since
+// they were last ingested and adds them to the data service. Songs from files
+// that no longer exist are removed.
 func (ds *DataService) Ingest() error {
+	_, err := ds.ingest()
+	return err
+}
+
+// ingest queues new and changed files and returns how many files were queued
+// or removed. Files referencing local libraries are always queued so the
+// libraries are rescanned, but are not counted unless they changed.
+func (ds *DataService) ingest() (int, error) {
 	files, err := os.ReadDir(ds.ingestPath)
 	if err != nil {
-		return fmt.Errorf("failed to read directory: %w", err)
-	}
-
-	// Count valid files first
-	validFiles := 0
-	for _, file := range files {
-		if !file.IsDir() && filepath.Ext(file.Name()) == ".json" {
-			validFiles++
-		}
+		return 0, fmt.Errorf("failed to read directory: %w", err)
 	}
 
-	// Pre-increment WaitGroup for all valid files
-	ds.activeJobs.Add(validFiles)
+	present := make(map[string]bool)
+	var changed, rescan []string
 
-	// Queue all files
+	ds.songsLock.RLock()
 	for _, file := range files {
 		if file.IsDir() {
 			continue
@@ -100,18 +147,97 @@ func (ds *DataService) Ingest() error {
 		}
 
 		filePath := filepath.Join(ds.ingestPath, file.Name())
+		present[filePath] = true
 
+		info, err := file.Info()
+		if err != nil {
+			continue
+		}
+
+		previous, exists := ds.files[filePath]
+		if !exists || !previous.modTime.Equal(info.ModTime()) || previous.size != info.Size() {
+			changed = append(changed, filePath)
+		} else if previous.local {
+			rescan = append(rescan, filePath)
+		}
+	}
+	ds.songsLock.RUnlock()
+
+	removed := ds.removeMissingFiles(present)
+
+	queue := append(changed, rescan...)
+
+	// Pre-increment WaitGroup for all queued files
+	ds.activeJobs.Add(len(queue))
+
+	// Queue all files
+	for i, filePath := range queue {
 		select {
 		case ds.workQueue <- filePath:
 			// Successfully queued
 		case <-ds.ctx.Done():
-			// Decrement WaitGroup for remaining files
-			ds.activeJobs.Add(-validFiles) // Subtract all files we added
-			return fmt.Errorf("data service is stopped")
+			// Decrement WaitGroup for the files we could not queue
+			ds.activeJobs.Add(-(len(queue) - i))
+			return removed + min(i, len(changed)), fmt.Errorf("data service is stopped")
 		}
 	}
 
-	return nil
+	return removed + len(changed), nil
+}
+
+// removeMissingFiles forgets every ingested file that is not in present and
+// returns how many were removed.
+func (ds *DataService) removeMissingFiles(present map[string]bool) int {
+	ds.songsLock.Lock()
+	defer ds.songsLock.Unlock()
+
+	removed := 0
+	for filePath := range ds.files {
+		if !present[filePath] {
+			fmt.Println("song list removed:", filepath.Base(filePath))
+			delete(ds.files, filePath)
+			removed++
+		}
+	}
+
+	if removed > 0 {
+		ds.rebuild()
+	}
+
+	return removed
+}
+
+// rebuild recomputes the song and submitter indexes from the ingested files.
+// The caller must hold songsLock.
+func (ds *DataService) rebuild() {
+	paths := make([]string, 0, len(ds.files))
+	for filePath := range ds.files {
+		paths = append(paths, filePath)
+	}
+	sort.Strings(paths)
+
+	songs := make([]*Song, 0, len(ds.Songs))
+	songIndex := make(map[string]*Song, len(ds.songIndex))
+	submitters := make(map[string]Submitter, len(paths))
+
+	for _, filePath := range paths {
+		file := ds.files[filePath]
+		songs = append(songs, file.songs...)
+		for _, song := range file.songs {
+			// A song listed by several submitters is credited to the first.
+			if _, exists := songIndex[song.ID()]; !exists {
+				songIndex[song.ID()] = song
+			}
+		}
+		submitters[file.submitter.Name] = file.submitter
+	}
+
+	ds.Songs = songs
+	ds.songIndex = songIndex
+
+	ds.submittersLock.Lock()
+	ds.Submitters = submitters
+	ds.submittersLock.Unlock()
 }
 
 // Start starts the data service workers.
@@ -141,17 +267,27 @@ func (ds *DataService) GetSong(id string) *Song {
 	ds.songsLock.RLock()
 	defer ds.songsLock.RUnlock()
 
-	for _, song := range ds.Songs {
-		if song.ID() == id {
-			return song
-		}
+	return ds.songIndex[id]
+}
+
+// GetSubmitter returns the submitter of the library song with the given ID.
+func (ds *DataService) GetSubmitter(songID string) (Submitter, bool) {
+	song := ds.GetSong(songID)
+	if song == nil || song.Submitter == nil {
+		return Submitter{}, false
 	}
 
-	return nil
+	return *song.Submitter, true
 }
 
-// ingestFile reads a JSON file and adds its contents to the data service.
+// ingestFile reads a JSON file and adds its contents to the data service,
+// replacing whatever the file contained when it was last ingested.
 func (ds *DataService) ingestFile(filePath string) error {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to stat file %s: %w", filePath, err)
+	}
+
 	data, err := os.ReadFile(filePath)
 	if err != nil {
 		return fmt.Errorf("failed to read file %s: %w", filePath, err)
@@ -162,18 +298,65 @@ func (ds *DataService) ingestFile(filePath string) error {
 		return fmt.Errorf("failed to parse file %s: %w", filePath, err)
 	}
 
-	// Add songs to data service
-	ds.songsLock.Lock()
-	ds.Songs = append(ds.Songs, songList.Songs...)
-	ds.songsLock.Unlock()
+	local := songList.Library != ""
+	songs := make([]*Song, 0, len(songList.Songs))
+	for _, song := range songList.Songs {
+		if song.IsLocal() {
+			local = true
+			localSongs, err := ds.localSongs(song.Path, song)
+			if err != nil {
+				fmt.Printf("skipping %s in %s: %v\n", song.Path, filepath.Base(filePath), err)
+				continue
+			}
+			songs = append(songs, localSongs...)
+			continue
+		}
 
-	// Add submitter to data service
-	ds.submittersLock.Lock()
-	ds.Submitters[songList.Name] = Submitter{
+		if song.ID() == "" {
+			fmt.Printf("skipping unsupported song %q in %s\n", song.URL, filepath.Base(filePath))
+			continue
+		}
+		songs = append(songs, song)
+	}
+
+	if songList.Library != "" {
+		librarySongs, err := ds.localSongs(songList.Library, nil)
+		if err != nil {
+			fmt.Printf("skipping library %s in %s: %v\n", songList.Library, filepath.Base(filePath), err)
+		}
+		songs = append(songs, librarySongs...)
+	}
+
+	// A track may be listed on its own and as part of a library.
+	seen := make(map[string]bool, len(songs))
+	unique := songs[:0]
+	for _, song := range songs {
+		if !seen[song.ID()] {
+			seen[song.ID()] = true
+			unique = append(unique, song)
+		}
+	}
+	songs = unique
+
+	submitter := &Submitter{
 		Name: songList.Name,
 		Pfp:  songList.Pfp,
 	}
-	ds.submittersLock.Unlock()
+	for _, song := range songs {
+		song.Submitter = submitter
+	}
+
+	ds.songsLock.Lock()
+	defer ds.songsLock.Unlock()
+
+	ds.files[filePath] = &ingestedFile{
+		modTime:   info.ModTime(),
+		size:      info.Size(),
+		submitter: *submitter,
+		songs:     songs,
+		local:     local,
+	}
+	ds.rebuild()
 
 	return nil
 }
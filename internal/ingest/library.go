@@ -0,0 +1,83 @@
+package ingest
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/feline-dis/go-radio/internal/library"
+)
+
+// localSongs scans a local file or directory and returns a song for every
+// track in it. Relative paths are resolved against the ingest directory.
+// Fields set on template override the tags of a single file.
+func (ds *DataService) localSongs(root string, template *Song) ([]*Song, error) {
+	if !filepath.IsAbs(root) {
+		root = filepath.Join(ds.ingestPath, root)
+	}
+
+	tracks, err := ds.scanner.Scan(root)
+	if err != nil {
+		return nil, err
+	}
+
+	songs := make([]*Song, 0, len(tracks))
+	for _, track := range tracks {
+		song := &Song{
+			Artist:   track.Artist,
+			Title:    track.Title,
+			Album:    track.Album,
+			Path:     track.Path,
+			Duration: track.Duration,
+			id:       track.ID,
+		}
+
+		if track.HasArt {
+			song.ArtUrl = ArtURL(track.ID)
+		}
+
+		if template != nil && len(tracks) == 1 {
+			overrideTags(song, template)
+		}
+
+		songs = append(songs, song)
+	}
+
+	if len(songs) == 0 {
+		fmt.Println("no tracks found in", root)
+	}
+
+	return songs, nil
+}
+
+func overrideTags(song, template *Song) {
+	if template.Artist != "" {
+		song.Artist = template.Artist
+	}
+	if template.Title != "" {
+		song.Title = template.Title
+	}
+	if template.Album != "" {
+		song.Album = template.Album
+	}
+	if template.ArtUrl != "" {
+		song.ArtUrl = template.ArtUrl
+	}
+}
+
+// ArtURL is where the cover art embedded in a local song is served.
+func ArtURL(id string) string {
+	return "/art/" + id
+}
+
+// IsLocal reports whether the song is a file in a local library.
+func (s Song) IsLocal() bool {
+	return s.Path != ""
+}
+
+// Art returns the cover art embedded in a local song.
+func (s Song) Art() (*library.Picture, error) {
+	if !s.IsLocal() {
+		return nil, library.ErrNoArt
+	}
+	return library.Art(s.Path)
+}
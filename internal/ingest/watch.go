@@ -0,0 +1,83 @@
+package ingest
+
+import (
+	"fmt"
+	"time"
+)
+
+// OnChange registers a handler that is called whenever a reload adds or
+// removes songs.
+func (ds *DataService) OnChange(handler ChangeHandler) {
+	ds.handlersLock.Lock()
+	defer ds.handlersLock.Unlock()
+	ds.changeHandlers = append(ds.changeHandlers, handler)
+}
+
+// Watch polls the ingest directory every interval and re-ingests song lists
+// that were added, changed or removed until the data service is stopped.
+func (ds *DataService) Watch(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				ds.reload()
+			case <-ds.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// reload re-ingests changed files and notifies change handlers.
+func (ds *DataService) reload() {
+	before := ds.songsByID()
+
+	changed, err := ds.ingest()
+	if err != nil {
+		fmt.Printf("failed to reload ingest directory: %v\n", err)
+	}
+	ds.WaitForJobs()
+
+	after := ds.songsByID()
+
+	var added, removed []*Song
+	for id, song := range after {
+		if _, exists := before[id]; !exists {
+			added = append(added, song)
+		}
+	}
+	for id, song := range before {
+		if _, exists := after[id]; !exists {
+			removed = append(removed, song)
+		}
+	}
+
+	if changed == 0 && len(added) == 0 && len(removed) == 0 {
+		return
+	}
+
+	fmt.Printf("ingest reloaded: %d songs added, %d removed\n", len(added), len(removed))
+
+	ds.handlersLock.Lock()
+	handlers := make([]ChangeHandler, len(ds.changeHandlers))
+	copy(handlers, ds.changeHandlers)
+	ds.handlersLock.Unlock()
+
+	for _, handler := range handlers {
+		handler(added, removed)
+	}
+}
+
+func (ds *DataService) songsByID() map[string]*Song {
+	ds.songsLock.RLock()
+	defer ds.songsLock.RUnlock()
+
+	songs := make(map[string]*Song, len(ds.Songs))
+	for _, song := range ds.Songs {
+		songs[song.ID()] = song
+	}
+	return songs
+}
@@ -0,0 +1,150 @@
+package library
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/feline-dis/go-radio/internal/source"
+)
+
+var errUnknownFormat = errors.New("unknown audio format")
+
+// duration returns the length of the audio file at path in whole seconds.
+func duration(path string) (int, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".mp3":
+		return source.ProbeDuration(path)
+	case ".flac":
+		return flacDuration(path)
+	case ".ogg", ".oga", ".opus":
+		return oggDuration(path)
+	}
+
+	return 0, errUnknownFormat
+}
+
+// flacDuration reads the sample count from the STREAMINFO block.
+func flacDuration(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer f.Close()
+
+	if err := skipID3v2(f); err != nil {
+		return 0, err
+	}
+
+	// "fLaC", then the STREAMINFO block header and its first 18 bytes.
+	var header [4 + 4 + 18]byte
+	if _, err := io.ReadFull(f, header[:]); err != nil {
+		return 0, fmt.Errorf("failed to read FLAC header: %w", err)
+	}
+
+	if string(header[:4]) != "fLaC" || header[4]&0x7f != 0 {
+		return 0, fmt.Errorf("missing FLAC STREAMINFO block")
+	}
+
+	// 20 bits of sample rate, 3 of channels, 5 of bits per sample and 36 of
+	// total samples.
+	info := binary.BigEndian.Uint64(header[18:26])
+	sampleRate := info >> 44
+	totalSamples := info & (1<<36 - 1)
+
+	if sampleRate == 0 {
+		return 0, fmt.Errorf("invalid FLAC sample rate")
+	}
+
+	return int((totalSamples + sampleRate/2) / sampleRate), nil
+}
+
+// oggDuration divides the granule position of the last page by the sample
+// rate from the Vorbis or Opus identification header.
+func oggDuration(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer f.Close()
+
+	var first [128]byte
+	n, err := io.ReadFull(f, first[:])
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return 0, fmt.Errorf("failed to read Ogg header: %w", err)
+	}
+
+	if n < 27 || string(first[:4]) != "OggS" {
+		return 0, fmt.Errorf("not an Ogg file")
+	}
+
+	// The first packet starts after the page header and its segment table.
+	packet := first[27+int(first[26]) : n]
+
+	var sampleRate, preSkip uint64
+	switch {
+	case len(packet) >= 16 && string(packet[:7]) == "\x01vorbis":
+		sampleRate = uint64(binary.LittleEndian.Uint32(packet[12:16]))
+	case len(packet) >= 12 && string(packet[:8]) == "OpusHead":
+		// Opus granule positions always count 48kHz samples.
+		sampleRate = 48000
+		preSkip = uint64(binary.LittleEndian.Uint16(packet[10:12]))
+	default:
+		return 0, errUnknownFormat
+	}
+
+	if sampleRate == 0 {
+		return 0, fmt.Errorf("invalid Ogg sample rate")
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("failed to stat file: %w", err)
+	}
+
+	// The last page is at most 64KiB long.
+	tailSize := min(info.Size(), 65536+27+255)
+	tail := make([]byte, tailSize)
+	if _, err := f.ReadAt(tail, info.Size()-tailSize); err != nil {
+		return 0, fmt.Errorf("failed to read Ogg tail: %w", err)
+	}
+
+	last := bytes.LastIndex(tail, []byte("OggS"))
+	if last < 0 || len(tail)-last < 14 {
+		return 0, fmt.Errorf("missing final Ogg page")
+	}
+
+	granule := binary.LittleEndian.Uint64(tail[last+6 : last+14])
+	if granule < preSkip {
+		return 0, nil
+	}
+
+	return int((granule - preSkip + sampleRate/2) / sampleRate), nil
+}
+
+// skipID3v2 moves past an ID3v2 tag at the start of f, if there is one.
+func skipID3v2(f io.ReadSeeker) error {
+	var header [10]byte
+	if _, err := io.ReadFull(f, header[:]); err != nil {
+		return fmt.Errorf("failed to read header: %w", err)
+	}
+
+	if string(header[:3]) != "ID3" {
+		_, err := f.Seek(0, io.SeekStart)
+		return err
+	}
+
+	size := int64(header[6])<<21 | int64(header[7])<<14 | int64(header[8])<<7 | int64(header[9])
+	if header[5]&0x10 != 0 {
+		// footer present
+		size += 10
+	}
+
+	_, err := f.Seek(size, io.SeekCurrent)
+	return err
+}
@@ -0,0 +1,204 @@
+package library
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dhowden/tag"
+)
+
+// Extensions are the audio file types a library may contain.
+var Extensions = map[string]bool{
+	".mp3":  true,
+	".flac": true,
+	".ogg":  true,
+	".oga":  true,
+	".opus": true,
+}
+
+// IDPrefix starts the ID of every local track.
+const IDPrefix = "local-"
+
+// Picture is cover art embedded in a track.
+type Picture = tag.Picture
+
+// ErrNoArt is returned when a file has no embedded cover art.
+var ErrNoArt = errors.New("no embedded cover art")
+
+// Track is an audio file in a local library.
+type Track struct {
+	Path   string
+	ID     string
+	Artist string
+	Title  string
+	Album  string
+	// Duration is the length of the track in seconds.
+	Duration int
+	HasArt   bool
+}
+
+// IsMP3 reports whether the track can be streamed without transcoding.
+func (t *Track) IsMP3() bool {
+	return strings.EqualFold(filepath.Ext(t.Path), ".mp3")
+}
+
+type cachedTrack struct {
+	size    int64
+	modTime time.Time
+	track   *Track
+}
+
+// Scanner reads tracks from local directories. Tracks are only re-read when
+// their size or modification time changes, so rescanning is cheap.
+type Scanner struct {
+	cache map[string]*cachedTrack
+	mu    sync.Mutex
+}
+
+// NewScanner creates a scanner with an empty cache.
+func NewScanner() *Scanner {
+	return &Scanner{
+		cache: make(map[string]*cachedTrack),
+	}
+}
+
+// Scan returns every track in root, which may be a directory or a single
+// file, sorted by path. Files that cannot be read are skipped.
+func (s *Scanner) Scan(root string) ([]*Track, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, fmt.Errorf("invalid library path %s: %w", root, err)
+	}
+
+	var tracks []*Track
+	err = filepath.WalkDir(root, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if entry.IsDir() || !Extensions[strings.ToLower(filepath.Ext(p))] {
+			return nil
+		}
+
+		track, err := s.Read(p)
+		if err != nil {
+			fmt.Printf("skipping %s: %v\n", p, err)
+			return nil
+		}
+
+		tracks = append(tracks, track)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan library %s: %w", root, err)
+	}
+
+	sort.Slice(tracks, func(i, j int) bool {
+		return tracks[i].Path < tracks[j].Path
+	})
+
+	return tracks, nil
+}
+
+// Read returns the track at path.
+func (s *Scanner) Read(path string) (*Track, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat file: %w", err)
+	}
+
+	s.mu.Lock()
+	cached, exists := s.cache[path]
+	s.mu.Unlock()
+
+	if exists && cached.size == info.Size() && cached.modTime.Equal(info.ModTime()) {
+		return cached.track, nil
+	}
+
+	track, err := readTrack(path)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.cache[path] = &cachedTrack{
+		size:    info.Size(),
+		modTime: info.ModTime(),
+		track:   track,
+	}
+	s.mu.Unlock()
+
+	return track, nil
+}
+
+func readTrack(path string) (*Track, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer f.Close()
+
+	// The checksum skips tags, so retagging a file keeps its ID.
+	sum, err := tag.Sum(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash audio: %w", err)
+	}
+
+	track := &Track{
+		Path: path,
+		ID:   IDPrefix + sum[:16],
+	}
+
+	if _, err := f.Seek(0, 0); err != nil {
+		return nil, fmt.Errorf("failed to seek: %w", err)
+	}
+
+	if metadata, err := tag.ReadFrom(f); err == nil {
+		track.Artist = metadata.Artist()
+		if track.Artist == "" {
+			track.Artist = metadata.AlbumArtist()
+		}
+		track.Title = metadata.Title()
+		track.Album = metadata.Album()
+		track.HasArt = metadata.Picture() != nil
+	}
+
+	if track.Title == "" {
+		name := filepath.Base(path)
+		track.Title = strings.TrimSuffix(name, filepath.Ext(name))
+	}
+
+	if track.Duration, err = duration(path); err != nil {
+		return nil, err
+	}
+
+	return track, nil
+}
+
+// Art returns the cover art embedded in the file at path.
+func Art(path string) (*Picture, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer f.Close()
+
+	metadata, err := tag.ReadFrom(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tags: %w", err)
+	}
+
+	picture := metadata.Picture()
+	if picture == nil {
+		return nil, ErrNoArt
+	}
+
+	return picture, nil
+}
@@ -0,0 +1,81 @@
+package loudness
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+const (
+	// DefaultTarget is the integrated loudness, in LUFS, tracks are
+	// normalized to.
+	DefaultTarget = -16.0
+	// MaxTruePeak is the highest true peak, in dBTP, a track may reach after
+	// normalization.
+	MaxTruePeak = -1.0
+)
+
+// Measurement is the EBU R128 loudness of a track.
+type Measurement struct {
+	// Integrated loudness in LUFS.
+	Integrated float64 `json:"integrated"`
+	// TruePeak in dBTP.
+	TruePeak float64 `json:"true_peak"`
+	// Range is the loudness range in LU.
+	Range float64 `json:"range"`
+}
+
+// Gain returns the gain in dB that brings the track to target, reduced if
+// needed so the true peak stays below MaxTruePeak.
+func (m *Measurement) Gain(target float64) float64 {
+	gain := target - m.Integrated
+	gain = math.Min(gain, MaxTruePeak-m.TruePeak)
+	return math.Round(gain*100) / 100
+}
+
+// loudnormOutput is the summary printed by ffmpeg's loudnorm filter.
+type loudnormOutput struct {
+	InputI   string `json:"input_i"`
+	InputTP  string `json:"input_tp"`
+	InputLRA string `json:"input_lra"`
+}
+
+// Filter is the ffmpeg audio filter that measures loudness. Its summary is
+// read from ffmpeg's output with Parse.
+const Filter = "loudnorm=print_format=json"
+
+// Parse reads the measurement from the output of an ffmpeg run with Filter.
+func Parse(output []byte) (*Measurement, error) {
+	// loudnorm prints its summary to stderr after ffmpeg's own logging.
+	start := bytes.LastIndexByte(output, '{')
+	end := bytes.LastIndexByte(output, '}')
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("loudnorm summary not found in ffmpeg output")
+	}
+
+	var summary loudnormOutput
+	if err := json.Unmarshal(output[start:end+1], &summary); err != nil {
+		return nil, fmt.Errorf("failed to parse loudnorm summary: %w", err)
+	}
+
+	var m Measurement
+	for _, field := range []struct {
+		value string
+		dest  *float64
+	}{
+		{summary.InputI, &m.Integrated},
+		{summary.InputTP, &m.TruePeak},
+		{summary.InputLRA, &m.Range},
+	} {
+		v, err := strconv.ParseFloat(field.value, 64)
+		if err != nil || math.IsInf(v, 0) || math.IsNaN(v) {
+			// silent tracks measure as -inf
+			return nil, fmt.Errorf("invalid loudness value %q", field.value)
+		}
+		*field.dest = v
+	}
+
+	return &m, nil
+}
@@ -0,0 +1,124 @@
+package loudness
+
+import (
+	"math"
+	"testing"
+)
+
+// loudnormLog is what ffmpeg prints to stderr for a run with Filter.
+const loudnormLog = `Input #0, mp3, from 'song.mp3':
+  Metadata:
+    encoder         : Lavf60.3.100
+  Duration: 00:03:32.53, start: 0.025057, bitrate: 128 kb/s
+  Stream #0:0: Audio: mp3, 44100 Hz, stereo, fltp, 128 kb/s
+Stream mapping:
+  Stream #0:0 -> #0:0 (mp3 (mp3float) -> pcm_s16le (native))
+Press [q] to stop, [?] for help
+Output #0, null, to 'pipe:':
+  Metadata:
+    encoder         : Lavf60.3.100
+  Stream #0:0: Audio: pcm_s16le, 192000 Hz, stereo, s16, 6144 kb/s
+size=N/A time=00:03:32.52 bitrate=N/A speed= 152x
+video:0kB audio:159396kB subtitle:0kB other streams:0kB global headers:0kB muxing overhead: unknown
+[Parsed_loudnorm_0 @ 0x5581d8c0] 
+{
+	"input_i" : "-9.21",
+	"input_tp" : "0.47",
+	"input_lra" : "5.60",
+	"input_thresh" : "-19.35",
+	"output_i" : "-16.02",
+	"output_tp" : "-1.50",
+	"output_lra" : "4.90",
+	"output_thresh" : "-26.11",
+	"normalization_type" : "dynamic",
+	"target_offset" : "0.02"
+}
+`
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		output  string
+		want    *Measurement
+		wantErr bool
+	}{
+		{
+			name:   "loudnorm summary",
+			output: loudnormLog,
+			want:   &Measurement{Integrated: -9.21, TruePeak: 0.47, Range: 5.6},
+		},
+		{
+			name: "after silencedetect",
+			output: `[silencedetect @ 0x55] silence_start: 0
+[silencedetect @ 0x55] silence_end: 1.2 | silence_duration: 1.2
+[Parsed_loudnorm_1 @ 0x56] 
+{
+	"input_i" : "-23.50",
+	"input_tp" : "-6.10",
+	"input_lra" : "11.00"
+}
+`,
+			want: &Measurement{Integrated: -23.5, TruePeak: -6.1, Range: 11},
+		},
+		{
+			name: "silent track",
+			output: `{
+	"input_i" : "-inf",
+	"input_tp" : "-inf",
+	"input_lra" : "0.00"
+}`,
+			wantErr: true,
+		},
+		{
+			name:    "no summary",
+			output:  "song.mp3: Invalid data found when processing input\n",
+			wantErr: true,
+		},
+		{
+			name:    "truncated summary",
+			output:  "{\n\t\"input_i\" : \"-9.21\",\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse([]byte(tt.output))
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Parse = %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if *got != *tt.want {
+				t.Errorf("Parse = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGain(t *testing.T) {
+	tests := []struct {
+		name        string
+		measurement Measurement
+		target      float64
+		want        float64
+	}{
+		{"quiet track", Measurement{Integrated: -23, TruePeak: -10}, DefaultTarget, 7},
+		{"loud track", Measurement{Integrated: -9.21, TruePeak: 0.47}, DefaultTarget, -6.79},
+		{"limited by true peak", Measurement{Integrated: -20, TruePeak: -3}, DefaultTarget, 2},
+		{"other target", Measurement{Integrated: -14, TruePeak: -5}, -14, 0},
+		{"rounded", Measurement{Integrated: -16.333, TruePeak: -10}, DefaultTarget, 0.33},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.measurement.Gain(tt.target); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("Gain = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package mix
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// format is the sample format both sides of a mix are converted to.
+const format = "aformat=sample_fmts=fltp:sample_rates=44100:channel_layouts=stereo"
+
+// Segment is the part of a song that goes into a mix.
+type Segment struct {
+	Path string
+	// Start is where the segment starts in the file.
+	Start time.Duration
+	// Gain in dB is applied to the segment before mixing.
+	Gain float64
+}
+
+// Crossfade renders fade long segments of from and to, fading from one into
+// the other, as an MP3 at dest.
+func Crossfade(ctx context.Context, dest string, from, to Segment, fade time.Duration) error {
+	filter := fmt.Sprintf(
+		"[0:a]%[1]s,volume=%.2fdB[from];[1:a]%[1]s,volume=%.2fdB[to];[from][to]acrossfade=d=%.3f",
+		format,
+		from.Gain,
+		to.Gain,
+		fade.Seconds(),
+	)
+
+	cmd := exec.CommandContext(ctx, "ffmpeg",
+		"-y",
+		"-loglevel", "error",
+		"-ss", seconds(from.Start), "-t", seconds(fade), "-i", from.Path,
+		"-ss", seconds(to.Start), "-t", seconds(fade), "-i", to.Path,
+		"-filter_complex", filter,
+		"-codec:a", "libmp3lame",
+		"-q:a", "2",
+		dest,
+	)
+
+	if output, err := cmd.CombinedOutput(); err != nil {
+		os.Remove(dest)
+		return fmt.Errorf("ffmpeg failed: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	return nil
+}
+
+func seconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
@@ -0,0 +1,179 @@
+package mp3
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// VBRHeader is the information in a Xing, Info or VBRI header frame at the
+// start of a file.
+type VBRHeader struct {
+	// Frames is the number of audio frames, excluding the header frame.
+	Frames int
+	// Bytes is the size of the audio, when known.
+	Bytes int
+	// EncoderDelay and EncoderPadding are the samples the encoder added to
+	// the start and end of the audio, from a LAME tag.
+	EncoderDelay   int
+	EncoderPadding int
+}
+
+// sideInfoSize returns the size of the Layer III side information that
+// follows the header and CRC.
+func (h FrameHeader) sideInfoSize() int {
+	mono := h.ChannelMode == ChannelMono
+	switch {
+	case h.Version == Version1 && mono:
+		return 17
+	case h.Version == Version1:
+		return 32
+	case mono:
+		return 9
+	default:
+		return 17
+	}
+}
+
+// ParseVBRHeader decodes a Xing, Info or VBRI header from frame. It reports
+// false if the frame is an ordinary audio frame.
+func ParseVBRHeader(frame *Frame) (*VBRHeader, bool) {
+	if frame.Header.Layer != 3 {
+		return nil, false
+	}
+
+	offset := HeaderSize + frame.Header.sideInfoSize()
+	if frame.Header.Protected {
+		offset += 2
+	}
+
+	if vbr, ok := parseXing(frame.Data, offset); ok {
+		return vbr, true
+	}
+
+	// VBRI headers are always 32 bytes after the header.
+	return parseVBRI(frame.Data, HeaderSize+32)
+}
+
+func parseXing(data []byte, offset int) (*VBRHeader, bool) {
+	if len(data) < offset+8 {
+		return nil, false
+	}
+
+	tag := string(data[offset : offset+4])
+	if tag != "Xing" && tag != "Info" {
+		return nil, false
+	}
+
+	flags := binary.BigEndian.Uint32(data[offset+4:])
+	pos := offset + 8
+
+	vbr := &VBRHeader{}
+	if flags&0x1 != 0 {
+		if len(data) < pos+4 {
+			return nil, false
+		}
+		vbr.Frames = int(binary.BigEndian.Uint32(data[pos:]))
+		pos += 4
+	}
+	if flags&0x2 != 0 {
+		if len(data) < pos+4 {
+			return nil, false
+		}
+		vbr.Bytes = int(binary.BigEndian.Uint32(data[pos:]))
+		pos += 4
+	}
+	if flags&0x4 != 0 {
+		// seek table
+		pos += 100
+	}
+	if flags&0x8 != 0 {
+		// quality indicator
+		pos += 4
+	}
+
+	// The LAME tag stores the encoder delay and padding as two 12 bit
+	// values 21 bytes in.
+	if len(data) >= pos+24 {
+		switch string(data[pos : pos+4]) {
+		case "LAME", "Lavc", "Lavf":
+			b := data[pos+21 : pos+24]
+			vbr.EncoderDelay = int(b[0])<<4 | int(b[1])>>4
+			vbr.EncoderPadding = int(b[1]&0x0F)<<8 | int(b[2])
+		}
+	}
+
+	return vbr, true
+}
+
+func parseVBRI(data []byte, offset int) (*VBRHeader, bool) {
+	if len(data) < offset+18 || string(data[offset:offset+4]) != "VBRI" {
+		return nil, false
+	}
+
+	return &VBRHeader{
+		Bytes:  int(binary.BigEndian.Uint32(data[offset+10:])),
+		Frames: int(binary.BigEndian.Uint32(data[offset+14:])),
+	}, true
+}
+
+// Duration returns the exact playback duration of the MP3 stream in r. The
+// frame count of a VBR header is used when there is one, otherwise every frame
+// is read.
+func Duration(r io.Reader) (time.Duration, error) {
+	reader := NewReader(r)
+
+	first, err := reader.ReadFrame()
+	if errors.Is(err, io.EOF) {
+		return 0, fmt.Errorf("no MPEG audio frames found")
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	header := first.Header
+
+	vbr, ok := ParseVBRHeader(first)
+	if ok && vbr.Frames > 0 {
+		samples := vbr.Frames*header.Samples() - vbr.EncoderDelay - vbr.EncoderPadding
+		return time.Duration(max(samples, 0)) * time.Second / time.Duration(header.SampleRate), nil
+	}
+
+	var total time.Duration
+	if !ok {
+		// the first frame is audio, not a header
+		total = header.Duration()
+	}
+
+	for {
+		frame, err := reader.ReadFrame()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		total += frame.Header.Duration()
+	}
+
+	return total, nil
+}
+
+// FileDuration returns the exact playback duration of the MP3 file at path.
+func FileDuration(path string) (time.Duration, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+
+	duration, err := Duration(f)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	return duration, nil
+}
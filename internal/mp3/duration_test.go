@@ -0,0 +1,151 @@
+package mp3
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// xingFrame returns a header frame with a Xing tag at offset. delay and
+// padding are written to a LAME tag when either is set.
+func xingFrame(t *testing.T, header []byte, offset int, tag string, frames, size, delay, padding int) []byte {
+	t.Helper()
+
+	frame := newFrame(t, header)
+	copy(frame[offset:], tag)
+	binary.BigEndian.PutUint32(frame[offset+4:], 0x1|0x2)
+	binary.BigEndian.PutUint32(frame[offset+8:], uint32(frames))
+	binary.BigEndian.PutUint32(frame[offset+12:], uint32(size))
+
+	if delay > 0 || padding > 0 {
+		lame := frame[offset+16:]
+		copy(lame, "LAME")
+		lame[21] = byte(delay >> 4)
+		lame[22] = byte(delay<<4) | byte(padding>>8)
+		lame[23] = byte(padding)
+	}
+
+	return frame
+}
+
+// vbriFrame returns a header frame with a VBRI tag.
+func vbriFrame(t *testing.T, frames, size int) []byte {
+	t.Helper()
+
+	frame := newFrame(t, stereoHeader)
+	vbri := frame[HeaderSize+32:]
+	copy(vbri, "VBRI")
+	binary.BigEndian.PutUint32(vbri[10:], uint32(size))
+	binary.BigEndian.PutUint32(vbri[14:], uint32(frames))
+	return frame
+}
+
+func samples(n int) time.Duration {
+	return time.Duration(n) * time.Second / 44100
+}
+
+func TestParseVBRHeader(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame []byte
+		want  *VBRHeader
+	}{
+		{
+			name:  "xing stereo",
+			frame: xingFrame(t, stereoHeader, HeaderSize+32, "Xing", 1000, 400000, 0, 0),
+			want:  &VBRHeader{Frames: 1000, Bytes: 400000},
+		},
+		{
+			name:  "info mono",
+			frame: xingFrame(t, monoHeader, HeaderSize+17, "Info", 20, 8000, 0, 0),
+			want:  &VBRHeader{Frames: 20, Bytes: 8000},
+		},
+		{
+			name:  "xing after crc",
+			frame: xingFrame(t, protectedHeader, HeaderSize+2+32, "Xing", 7, 0, 0, 0),
+			want:  &VBRHeader{Frames: 7},
+		},
+		{
+			name:  "lame tag",
+			frame: xingFrame(t, stereoHeader, HeaderSize+32, "Xing", 1000, 400000, 576, 1234),
+			want:  &VBRHeader{Frames: 1000, Bytes: 400000, EncoderDelay: 576, EncoderPadding: 1234},
+		},
+		{
+			name:  "vbri",
+			frame: vbriFrame(t, 500, 200000),
+			want:  &VBRHeader{Frames: 500, Bytes: 200000},
+		},
+		{
+			name:  "audio frame",
+			frame: newFrame(t, stereoHeader),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header, _ := ParseHeader(tt.frame)
+			got, ok := ParseVBRHeader(&Frame{Header: header, Data: tt.frame})
+			if ok != (tt.want != nil) {
+				t.Fatalf("ParseVBRHeader reported %v, want %v", ok, tt.want != nil)
+			}
+			if ok && *got != *tt.want {
+				t.Errorf("ParseVBRHeader = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDuration(t *testing.T) {
+	audio := newFrame(t, stereoHeader)
+
+	tests := []struct {
+		name   string
+		frames [][]byte
+		want   time.Duration
+	}{
+		{
+			name:   "xing frame count",
+			frames: [][]byte{xingFrame(t, stereoHeader, HeaderSize+32, "Xing", 1000, 0, 0, 0), audio},
+			want:   samples(1000 * 1152),
+		},
+		{
+			name:   "encoder delay and padding",
+			frames: [][]byte{xingFrame(t, stereoHeader, HeaderSize+32, "Xing", 1000, 0, 576, 1152), audio},
+			want:   samples(1000*1152 - 576 - 1152),
+		},
+		{
+			name:   "vbri frame count",
+			frames: [][]byte{vbriFrame(t, 250, 0), audio},
+			want:   samples(250 * 1152),
+		},
+		{
+			name:   "counted frames",
+			frames: [][]byte{audio, audio, audio},
+			want:   3 * samples(1152),
+		},
+		{
+			name:   "header without frame count",
+			frames: [][]byte{xingFrame(t, stereoHeader, HeaderSize+32, "Xing", 0, 0, 0, 0), audio, audio},
+			want:   2 * samples(1152),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Duration(bytes.NewReader(bytes.Join(tt.frames, nil)))
+			if err != nil {
+				t.Fatalf("Duration: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Duration = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDurationWithoutFrames(t *testing.T) {
+	if _, err := Duration(bytes.NewReader([]byte("not an mp3"))); err == nil {
+		t.Error("Duration succeeded without frames")
+	}
+}
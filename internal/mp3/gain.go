@@ -0,0 +1,113 @@
+package mp3
+
+import "math"
+
+// GainStep is the change in loudness, in dB, of a single global gain step.
+const GainStep = 1.5
+
+// GainSteps converts a gain in dB to the nearest number of global gain steps.
+func GainSteps(db float64) int {
+	return int(math.Round(db / GainStep))
+}
+
+// AdjustGain changes the loudness of a Layer III frame in place by adding
+// steps to the global gain of every granule and channel. The frame's CRC is
+// updated if it has one. Other layers are left unchanged.
+func (f *Frame) AdjustGain(steps int) {
+	h := f.Header
+	if steps == 0 || h.Layer != 3 {
+		return
+	}
+
+	sideInfo := HeaderSize
+	if h.Protected {
+		sideInfo += 2
+	}
+	if len(f.Data) < sideInfo+h.sideInfoSize() {
+		return
+	}
+
+	channels := 2
+	if h.ChannelMode == ChannelMono {
+		channels = 1
+	}
+
+	// Bits before the first granule and the size of each granule's per
+	// channel information.
+	var start, granuleSize, granules int
+	if h.Version == Version1 {
+		// main_data_begin, private bits and scfsi
+		start = 9 + 4*channels
+		if channels == 1 {
+			start += 5
+		} else {
+			start += 3
+		}
+		granuleSize, granules = 59, 2
+	} else {
+		// main_data_begin and private bits
+		start = 8 + channels
+		granuleSize, granules = 63, 1
+	}
+
+	// global_gain follows part2_3_length and big_values.
+	const globalGainOffset = 12 + 9
+
+	bits := f.Data[sideInfo:]
+	for gr := 0; gr < granules; gr++ {
+		for ch := 0; ch < channels; ch++ {
+			pos := start + (gr*channels+ch)*granuleSize + globalGainOffset
+			gain := readBits(bits, pos, 8)
+			if gain == 0 {
+				// leave digital silence alone
+				continue
+			}
+			writeBits(bits, pos, 8, min(max(gain+steps, 1), 255))
+		}
+	}
+
+	if h.Protected {
+		crc := crc16(f.Data[2:4], f.Data[sideInfo:sideInfo+h.sideInfoSize()])
+		f.Data[4] = byte(crc >> 8)
+		f.Data[5] = byte(crc)
+	}
+}
+
+func readBits(b []byte, pos, n int) int {
+	v := 0
+	for i := 0; i < n; i++ {
+		bit := (b[(pos+i)/8] >> (7 - uint((pos+i)%8))) & 1
+		v = v<<1 | int(bit)
+	}
+	return v
+}
+
+func writeBits(b []byte, pos, n, v int) {
+	for i := 0; i < n; i++ {
+		mask := byte(1) << (7 - uint((pos+i)%8))
+		if (v>>(n-1-i))&1 == 1 {
+			b[(pos+i)/8] |= mask
+		} else {
+			b[(pos+i)/8] &^= mask
+		}
+	}
+}
+
+// crc16 computes the MPEG audio CRC over the last two header bytes and the
+// side information.
+func crc16(parts ...[]byte) uint16 {
+	crc := uint16(0xFFFF)
+	for _, part := range parts {
+		for _, b := range part {
+			for i := 7; i >= 0; i-- {
+				bit := (b>>uint(i))&1 == 1
+				top := crc&0x8000 != 0
+				crc <<= 1
+				if top != bit {
+					crc ^= 0x8005
+				}
+			}
+		}
+	}
+	return crc
+}
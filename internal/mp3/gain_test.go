@@ -0,0 +1,132 @@
+package mp3
+
+import "testing"
+
+// Bit positions of global_gain in the side information of an MPEG1 stereo
+// frame, for each granule and channel.
+var stereoGainBits = []int{41, 100, 159, 218}
+
+func TestGainSteps(t *testing.T) {
+	tests := []struct {
+		db   float64
+		want int
+	}{
+		{0, 0},
+		{1.5, 1},
+		{-3, -2},
+		{2.2, 1},
+		{2.3, 2},
+		{-7.6, -5},
+	}
+
+	for _, tt := range tests {
+		if got := GainSteps(tt.db); got != tt.want {
+			t.Errorf("GainSteps(%v) = %d, want %d", tt.db, got, tt.want)
+		}
+	}
+}
+
+func TestAdjustGain(t *testing.T) {
+	tests := []struct {
+		name  string
+		gains []int
+		steps int
+		want  []int
+	}{
+		{"louder", []int{100, 120, 140, 160}, 5, []int{105, 125, 145, 165}},
+		{"quieter", []int{100, 120, 140, 160}, -10, []int{90, 110, 130, 150}},
+		{"clamped high", []int{250, 255, 200, 100}, 10, []int{255, 255, 210, 110}},
+		{"clamped low", []int{5, 1, 20, 2}, -10, []int{1, 1, 10, 1}},
+		{"silence kept", []int{0, 100, 0, 100}, 10, []int{0, 110, 0, 110}},
+		{"no steps", []int{100, 120, 140, 160}, 0, []int{100, 120, 140, 160}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := newFrame(t, stereoHeader)
+			sideInfo := data[HeaderSize:]
+			for i, pos := range stereoGainBits {
+				writeBits(sideInfo, pos, 8, tt.gains[i])
+			}
+			// Neighbouring fields must not be touched.
+			sideInfo[0] = 0xAB
+
+			header, _ := ParseHeader(data)
+			frame := &Frame{Header: header, Data: data}
+			frame.AdjustGain(tt.steps)
+
+			for i, pos := range stereoGainBits {
+				if got := readBits(sideInfo, pos, 8); got != tt.want[i] {
+					t.Errorf("global_gain %d = %d, want %d", i, got, tt.want[i])
+				}
+			}
+			if sideInfo[0] != 0xAB {
+				t.Errorf("main_data_begin changed to %#x", sideInfo[0])
+			}
+		})
+	}
+}
+
+func TestAdjustGainMPEG2Mono(t *testing.T) {
+	data := newFrame(t, []byte{0xFF, 0xF3, 0x84, 0xC0})
+	// main_data_begin (8 bits), private bit, then part2_3_length and
+	// big_values.
+	const pos = 9 + 12 + 9
+	writeBits(data[HeaderSize:], pos, 8, 150)
+
+	header, _ := ParseHeader(data)
+	(&Frame{Header: header, Data: data}).AdjustGain(-4)
+
+	if got := readBits(data[HeaderSize:], pos, 8); got != 146 {
+		t.Errorf("global_gain = %d, want 146", got)
+	}
+}
+
+func TestAdjustGainUpdatesCRC(t *testing.T) {
+	data := newFrame(t, protectedHeader)
+	sideInfo := data[HeaderSize+2:]
+	for _, pos := range stereoGainBits {
+		writeBits(sideInfo, pos, 8, 100)
+	}
+
+	header, _ := ParseHeader(data)
+	(&Frame{Header: header, Data: data}).AdjustGain(3)
+
+	if got := readBits(sideInfo, stereoGainBits[0], 8); got != 103 {
+		t.Fatalf("global_gain = %d, want 103", got)
+	}
+
+	want := crc16(data[2:4], sideInfo[:header.sideInfoSize()])
+	if got := uint16(data[4])<<8 | uint16(data[5]); got != want {
+		t.Errorf("CRC = %#04x, want %#04x", got, want)
+	}
+}
+
+func TestCRC16(t *testing.T) {
+	tests := []struct {
+		parts [][]byte
+		want  uint16
+	}{
+		// CRC-16 with polynomial 0x8005 and initial value 0xFFFF.
+		{[][]byte{[]byte("123456789")}, 0xAEE7},
+		{[][]byte{[]byte("1234"), []byte("56789")}, 0xAEE7},
+		{nil, 0xFFFF},
+	}
+
+	for _, tt := range tests {
+		if got := crc16(tt.parts...); got != tt.want {
+			t.Errorf("crc16(%q) = %#04x, want %#04x", tt.parts, got, tt.want)
+		}
+	}
+}
+
+func TestBits(t *testing.T) {
+	b := make([]byte, 3)
+	writeBits(b, 5, 11, 0x5A5)
+	if got := readBits(b, 5, 11); got != 0x5A5 {
+		t.Errorf("readBits = %#x, want 0x5a5", got)
+	}
+	if b[0]&0xF8 != 0 || b[2]&0x7F != 0 {
+		t.Errorf("writeBits touched bits outside the field: % x", b)
+	}
+}
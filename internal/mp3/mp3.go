@@ -0,0 +1,236 @@
+package mp3
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Version is the MPEG audio version of a frame.
+type Version int
+
+const (
+	Version25 Version = iota
+	VersionReserved
+	Version2
+	Version1
+)
+
+// ChannelMode is the channel layout of a frame.
+type ChannelMode int
+
+const (
+	ChannelStereo ChannelMode = iota
+	ChannelJointStereo
+	ChannelDualChannel
+	ChannelMono
+)
+
+// HeaderSize is the size in bytes of an MPEG audio frame header.
+const HeaderSize = 4
+
+var ErrInvalidHeader = errors.New("invalid frame header")
+
+// bitrates in kbps indexed by [version is MPEG1][layer-1][index].
+var bitrates = [2][3][16]int{
+	// MPEG2 and MPEG2.5
+	{
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256, 0},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},
+	},
+	// MPEG1
+	{
+		{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448, 0},
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384, 0},
+		{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0},
+	},
+}
+
+// sampleRates in Hz indexed by [version][index].
+var sampleRates = [4][3]int{
+	Version25:       {11025, 12000, 8000},
+	VersionReserved: {0, 0, 0},
+	Version2:        {22050, 24000, 16000},
+	Version1:        {44100, 48000, 32000},
+}
+
+// FrameHeader is a decoded MPEG audio frame header.
+type FrameHeader struct {
+	Version     Version
+	Layer       int
+	Protected   bool
+	Bitrate     int // bits per second
+	SampleRate  int
+	Padding     bool
+	ChannelMode ChannelMode
+}
+
+// ParseHeader decodes the 4 byte frame header at the start of b.
+func ParseHeader(b []byte) (FrameHeader, error) {
+	var h FrameHeader
+
+	if len(b) < HeaderSize {
+		return h, ErrInvalidHeader
+	}
+
+	if b[0] != 0xFF || b[1]&0xE0 != 0xE0 {
+		return h, ErrInvalidHeader
+	}
+
+	h.Version = Version((b[1] >> 3) & 0x03)
+	if h.Version == VersionReserved {
+		return h, ErrInvalidHeader
+	}
+
+	layerBits := (b[1] >> 1) & 0x03
+	if layerBits == 0 {
+		return h, ErrInvalidHeader
+	}
+	h.Layer = 4 - int(layerBits)
+
+	// The protection bit is inverted: 0 means a CRC follows the header.
+	h.Protected = b[1]&0x01 == 0
+
+	bitrateIndex := (b[2] >> 4) & 0x0F
+	if bitrateIndex == 0 || bitrateIndex == 0x0F {
+		// free format and "bad" bitrates are not supported
+		return h, ErrInvalidHeader
+	}
+
+	mpeg1 := 0
+	if h.Version == Version1 {
+		mpeg1 = 1
+	}
+	h.Bitrate = bitrates[mpeg1][h.Layer-1][bitrateIndex] * 1000
+
+	sampleRateIndex := (b[2] >> 2) & 0x03
+	if sampleRateIndex == 0x03 {
+		return h, ErrInvalidHeader
+	}
+	h.SampleRate = sampleRates[h.Version][sampleRateIndex]
+
+	h.Padding = (b[2]>>1)&0x01 == 1
+	h.ChannelMode = ChannelMode((b[3] >> 6) & 0x03)
+
+	return h, nil
+}
+
+// Samples returns the number of samples per channel encoded in the frame.
+func (h FrameHeader) Samples() int {
+	switch h.Layer {
+	case 1:
+		return 384
+	case 2:
+		return 1152
+	default:
+		if h.Version == Version1 {
+			return 1152
+		}
+		return 576
+	}
+}
+
+// Size returns the size of the whole frame in bytes, including the header.
+func (h FrameHeader) Size() int {
+	if h.Layer == 1 {
+		size := 12 * h.Bitrate / h.SampleRate
+		if h.Padding {
+			size++
+		}
+		return size * 4
+	}
+
+	size := h.Samples() / 8 * h.Bitrate / h.SampleRate
+	if h.Padding {
+		size++
+	}
+	return size
+}
+
+// Duration returns the playback duration of the frame.
+func (h FrameHeader) Duration() time.Duration {
+	return time.Duration(h.Samples()) * time.Second / time.Duration(h.SampleRate)
+}
+
+// Frame is a single MPEG audio frame including its header.
+type Frame struct {
+	Header FrameHeader
+	Data   []byte
+}
+
+// Reader reads MPEG audio frames from a stream, skipping ID3 tags and any
+// garbage between frames.
+type Reader struct {
+	r *bufio.Reader
+}
+
+// NewReader creates a new frame reader.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{
+		r: bufio.NewReaderSize(r, 16*1024),
+	}
+}
+
+// ReadFrame returns the next frame in the stream or io.EOF when the stream is exhausted.
+func (fr *Reader) ReadFrame() (*Frame, error) {
+	for {
+		peek, err := fr.r.Peek(HeaderSize)
+		if err != nil {
+			if len(peek) == 0 || errors.Is(err, io.EOF) {
+				return nil, io.EOF
+			}
+			return nil, err
+		}
+
+		if string(peek[:3]) == "ID3" {
+			if err := fr.skipID3v2(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		header, err := ParseHeader(peek)
+		if err != nil {
+			// resync one byte at a time until a valid header is found
+			if _, err := fr.r.Discard(1); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		data := make([]byte, header.Size())
+		if _, err := io.ReadFull(fr.r, data); err != nil {
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				// truncated final frame
+				return nil, io.EOF
+			}
+			return nil, err
+		}
+
+		return &Frame{Header: header, Data: data}, nil
+	}
+}
+
+// skipID3v2 discards an ID3v2 tag at the current position.
+func (fr *Reader) skipID3v2() error {
+	header, err := fr.r.Peek(10)
+	if err != nil {
+		return fmt.Errorf("failed to read ID3 header: %w", err)
+	}
+
+	size := int(header[6]&0x7F)<<21 | int(header[7]&0x7F)<<14 | int(header[8]&0x7F)<<7 | int(header[9]&0x7F)
+	size += 10
+	if header[5]&0x10 != 0 {
+		// footer present
+		size += 10
+	}
+
+	if _, err := fr.r.Discard(size); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to skip ID3 tag: %w", err)
+	}
+
+	return nil
+}
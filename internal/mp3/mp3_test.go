@@ -0,0 +1,132 @@
+package mp3
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// MPEG1 Layer III, 128 kbps, 44.1 kHz frame headers.
+var (
+	stereoHeader    = []byte{0xFF, 0xFB, 0x90, 0x00}
+	monoHeader      = []byte{0xFF, 0xFB, 0x90, 0xC0}
+	protectedHeader = []byte{0xFF, 0xFA, 0x90, 0x00}
+)
+
+// newFrame returns a zeroed frame of the size header describes.
+func newFrame(t *testing.T, header []byte) []byte {
+	t.Helper()
+
+	h, err := ParseHeader(header)
+	if err != nil {
+		t.Fatalf("ParseHeader(% x): %v", header, err)
+	}
+
+	frame := make([]byte, h.Size())
+	copy(frame, header)
+	return frame
+}
+
+func TestParseHeader(t *testing.T) {
+	tests := []struct {
+		name   string
+		header []byte
+		want   FrameHeader
+		size   int
+	}{
+		{
+			name:   "mpeg1 layer3 stereo",
+			header: stereoHeader,
+			want:   FrameHeader{Version: Version1, Layer: 3, Bitrate: 128000, SampleRate: 44100, ChannelMode: ChannelStereo},
+			size:   417,
+		},
+		{
+			name:   "mpeg1 layer3 padded mono with crc",
+			header: []byte{0xFF, 0xFA, 0x92, 0xC0},
+			want:   FrameHeader{Version: Version1, Layer: 3, Protected: true, Bitrate: 128000, SampleRate: 44100, Padding: true, ChannelMode: ChannelMono},
+			size:   418,
+		},
+		{
+			name:   "mpeg2 layer3 24 kHz",
+			header: []byte{0xFF, 0xF3, 0x84, 0x00},
+			want:   FrameHeader{Version: Version2, Layer: 3, Bitrate: 64000, SampleRate: 24000, ChannelMode: ChannelStereo},
+			size:   192,
+		},
+		{
+			name:   "mpeg1 layer2 48 kHz",
+			header: []byte{0xFF, 0xFD, 0xA4, 0x00},
+			want:   FrameHeader{Version: Version1, Layer: 2, Bitrate: 192000, SampleRate: 48000, ChannelMode: ChannelStereo},
+			size:   576,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseHeader(tt.header)
+			if err != nil {
+				t.Fatalf("ParseHeader: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("ParseHeader = %+v, want %+v", got, tt.want)
+			}
+			if got.Size() != tt.size {
+				t.Errorf("Size = %d, want %d", got.Size(), tt.size)
+			}
+		})
+	}
+}
+
+func TestParseHeaderInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		header []byte
+	}{
+		{"short", []byte{0xFF, 0xFB}},
+		{"no sync", []byte{0xFE, 0xFB, 0x90, 0x00}},
+		{"reserved version", []byte{0xFF, 0xEB, 0x90, 0x00}},
+		{"reserved layer", []byte{0xFF, 0xF9, 0x90, 0x00}},
+		{"free format", []byte{0xFF, 0xFB, 0x00, 0x00}},
+		{"bad bitrate", []byte{0xFF, 0xFB, 0xF0, 0x00}},
+		{"reserved sample rate", []byte{0xFF, 0xFB, 0x9C, 0x00}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseHeader(tt.header); err != ErrInvalidHeader {
+				t.Errorf("ParseHeader(% x) = %v, want ErrInvalidHeader", tt.header, err)
+			}
+		})
+	}
+}
+
+func TestReaderSkipsTagsAndGarbage(t *testing.T) {
+	var stream bytes.Buffer
+	// ID3v2 tag with a 5 byte body.
+	stream.Write([]byte{'I', 'D', '3', 4, 0, 0, 0, 0, 0, 5, 1, 2, 3, 4, 5})
+	stream.Write(newFrame(t, stereoHeader))
+	stream.Write([]byte{0x00, 0xFF, 0x12})
+	stream.Write(newFrame(t, stereoHeader))
+	// Truncated final frame.
+	stream.Write(newFrame(t, stereoHeader)[:100])
+
+	reader := NewReader(&stream)
+	frames := 0
+	for {
+		_, err := reader.ReadFrame()
+		if err != nil {
+			break
+		}
+		frames++
+	}
+
+	if frames != 2 {
+		t.Errorf("read %d frames, want 2", frames)
+	}
+}
+
+func TestFrameDuration(t *testing.T) {
+	h, _ := ParseHeader(stereoHeader)
+	if got, want := h.Duration(), 1152*time.Second/44100; got != want {
+		t.Errorf("Duration = %v, want %v", got, want)
+	}
+}
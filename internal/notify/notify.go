@@ -0,0 +1,56 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// sendTimeout bounds how long posting a notification may take.
+const sendTimeout = 10 * time.Second
+
+// Webhook sends notifications to admins through a Discord compatible webhook.
+type Webhook struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhook creates a notifier posting to url.
+func NewWebhook(url string) *Webhook {
+	return &Webhook{
+		url:    url,
+		client: http.DefaultClient,
+	}
+}
+
+// Notify posts message to the webhook.
+func (w *Webhook) Notify(ctx context.Context, message string) error {
+	body, err := json.Marshal(map[string]string{"content": message})
+	if err != nil {
+		return fmt.Errorf("failed to marshal notification: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, sendTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send notification: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("failed to send notification: %s", resp.Status)
+	}
+
+	return nil
+}
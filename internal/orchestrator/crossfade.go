@@ -0,0 +1,108 @@
+package orchestrator
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/feline-dis/go-radio/internal/download"
+	"github.com/feline-dis/go-radio/internal/ingest"
+	"github.com/feline-dis/go-radio/internal/mix"
+	"github.com/feline-dis/go-radio/internal/mp3"
+)
+
+// mixDir is the directory in the cache rendered crossfades are stored in.
+const mixDir = "mix"
+
+// crossfade is a rendered transition from the current song into the next.
+type crossfade struct {
+	path string
+	// to is the song being faded into and info how it was downloaded when
+	// the fade was rendered.
+	to   *ingest.Song
+	info *download.SongInfo
+	fade time.Duration
+}
+
+// matches reports whether the fade was rendered with the cue-in point and
+// gain the next song plays with. Both change once a song has been analyzed.
+func (c *crossfade) matches(info *download.SongInfo) bool {
+	return c.info.CueIn == info.CueIn && mp3.GainSteps(c.info.Gain) == mp3.GainSteps(info.Gain)
+}
+
+// mixPath returns where the crossfade between two songs is rendered.
+func (o *Orchestrator) mixPath(from, to *ingest.Song) string {
+	return filepath.Join(o.downloadService.CachePath, mixDir, fmt.Sprintf("%s-%s-%s.mp3", o.name, from.ID(), to.ID()))
+}
+
+// removeMixes deletes crossfades left over from a previous run.
+func (o *Orchestrator) removeMixes() {
+	paths, _ := filepath.Glob(filepath.Join(o.downloadService.CachePath, mixDir, o.name+"-*.mp3"))
+	for _, p := range paths {
+		os.Remove(p)
+	}
+}
+
+// prepareCrossfade renders the fade from current into next once next has
+// downloaded. The fade is only used if it is ready before it has to start;
+// otherwise the songs are cut as usual.
+func (o *Orchestrator) prepareCrossfade(current *SongState, next *ingest.Song) {
+	fade := o.crossfadeDuration
+
+	nextInfo, err := o.waitForDownload(next.ID())
+	if err != nil {
+		fmt.Printf("Not crossfading into %s: %v\n", next.ID(), err)
+		return
+	}
+
+	// Both songs must be long enough to fade in and out.
+	if current.duration < 2*fade || nextInfo.PlayDuration() < 2*fade {
+		return
+	}
+
+	dest := o.mixPath(current.song, next)
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		fmt.Printf("Failed to create mix directory: %v\n", err)
+		return
+	}
+
+	// The stream applies gain in whole steps, so the mix must too or the
+	// level would jump at either end of the fade.
+	err = mix.Crossfade(context.Background(), dest,
+		mix.Segment{
+			Path:  current.info.Path,
+			Start: current.info.CueOut - fade,
+			Gain:  float64(mp3.GainSteps(current.gain)) * mp3.GainStep,
+		},
+		mix.Segment{
+			Path:  nextInfo.Path,
+			Start: nextInfo.CueIn,
+			Gain:  float64(mp3.GainSteps(nextInfo.Gain)) * mp3.GainStep,
+		},
+		fade,
+	)
+	if err != nil {
+		fmt.Printf("Failed to render crossfade into %s: %v\n", next.ID(), err)
+		return
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	// Give up if the song was skipped, the queue changed or the fade should
+	// already have started.
+	if o.current != current || o.next.song != next || current.skipped || time.Until(current.endTime.Add(-fade)) <= 0 {
+		os.Remove(dest)
+		return
+	}
+
+	current.fadeOut = fade
+	o.crossfade = &crossfade{
+		path: dest,
+		to:   next,
+		info: nextInfo,
+		fade: fade,
+	}
+}
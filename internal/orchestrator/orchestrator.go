@@ -2,11 +2,16 @@ package orchestrator
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/feline-dis/go-radio/internal/controller"
 	"github.com/feline-dis/go-radio/internal/download"
+	"github.com/feline-dis/go-radio/internal/events"
+	"github.com/feline-dis/go-radio/internal/history"
 	"github.com/feline-dis/go-radio/internal/ingest"
 	"github.com/feline-dis/go-radio/internal/picker"
+	"github.com/feline-dis/go-radio/internal/stream"
+	"os"
 	"sync"
 	"time"
 )
@@ -15,36 +20,88 @@ type SongState struct {
 	song      *ingest.Song
 	startTime time.Time
 	endTime   time.Time
-	duration  int
+	duration  time.Duration
+	gain      float64
+	// cueIn is where playback starts in the song's file.
+	cueIn time.Duration
+	// fadeOut is how long the end of the song overlaps the next song. It is
+	// set once the crossfade has been rendered.
+	fadeOut   time.Duration
+	info      *download.SongInfo
+	listeners int
+	skipped   bool
+}
+
+// historySize is the number of finished songs kept in memory.
+const historySize = 100
+
+// pickRetryInterval is how long to wait before asking the picker again when it
+// has no songs.
+const pickRetryInterval = 5 * time.Second
+
+// Options tune the behaviour of an orchestrator.
+type Options struct {
+	SkipThreshold SkipThreshold
+	// Crossfade is how long consecutive songs overlap. Songs are cut
+	// without a fade when zero.
+	Crossfade time.Duration
 }
 
 type Orchestrator struct {
+	name                string
 	downloadService     *download.DownloadService
+	dataService         *ingest.DataService
 	pickerService       *picker.PickerService
 	websocketController *controller.WebsocketController
+	broadcaster         *stream.Broadcaster
+	historyStore        *history.Store
 	current             *SongState
 	next                *SongState
-	mu                  sync.RWMutex
+	history             []*SongState
+	skipThreshold       SkipThreshold
+	crossfadeDuration   time.Duration
+	// crossfade is the rendered fade from the current song into the next.
+	crossfade *crossfade
+	// playingMix is the file of the last crossfade sent to the stream.
+	playingMix string
+	// skipVotes holds the addresses of listeners that voted to skip the
+	// current song, so opening more connections does not add votes.
+	skipVotes map[string]bool
+	mu        sync.RWMutex
 }
 
-func NewOrchestrator(downloadService *download.DownloadService, pickerService *picker.PickerService, wsc *controller.WebsocketController) *Orchestrator {
-	return &Orchestrator{
+// NewOrchestrator creates a new orchestrator. historyStore may be nil, in which
+// case play history is only kept in memory.
+func NewOrchestrator(name string, downloadService *download.DownloadService, dataService *ingest.DataService, pickerService *picker.PickerService, wsc *controller.WebsocketController, broadcaster *stream.Broadcaster, historyStore *history.Store, options Options) *Orchestrator {
+	o := &Orchestrator{
+		name:                name,
 		downloadService:     downloadService,
+		dataService:         dataService,
 		pickerService:       pickerService,
 		websocketController: wsc,
+		broadcaster:         broadcaster,
+		historyStore:        historyStore,
+		skipThreshold:       options.SkipThreshold,
+		crossfadeDuration:   options.Crossfade,
+		skipVotes:           make(map[string]bool),
+	}
+
+	wsc.HandleMessage(controller.MessageTypeVoteSkip, o.handleVoteSkip)
+	downloadService.AddPinned(o)
+	pickerService.OnChange(o.broadcastQueue)
+	if downloadService.Events != nil {
+		downloadService.Events.Subscribe(download.TopicProgress, o.forwardDownloadProgress)
 	}
+
+	return o
 }
 
 func (o *Orchestrator) Start() {
 	ctx := context.Background()
-	o.downloadService.Start()
-	o.pickerService.ShuffleQueue()
+	o.removeMixes()
 
 	// Initialize first two songs
-	if err := o.initializeFirstSongs(); err != nil {
-		fmt.Printf("Failed to initialize first songs: %v\n", err)
-		return
-	}
+	o.initializeFirstSongs()
 
 	// Start the main playback loop
 	go o.runPlaybackLoop(ctx)
@@ -53,48 +110,85 @@ func (o *Orchestrator) Start() {
 	go o.runTransitionTimer(ctx)
 }
 
-func (o *Orchestrator) initializeFirstSongs() error {
+// initializeFirstSongs starts playing the first song that downloads. Songs
+// that fail are skipped, as they are when transitioning between songs.
+func (o *Orchestrator) initializeFirstSongs() {
 	// Get and prepare the first two songs
-	firstSong := o.pickerService.NextSong()
-	secondSong := o.pickerService.NextSong()
+	firstSong := o.pickSong()
+	secondSong := o.pickSong()
 
 	// Queue both downloads
 	o.downloadService.QueueDownload(firstSong)
 	o.downloadService.QueueDownload(secondSong)
 
-	// Wait for first song to be ready
-	info, err := o.waitForDownload(firstSong.ID())
-	if err != nil {
-		return fmt.Errorf("failed to download first song: %w", err)
+	var info *download.SongInfo
+	for {
+		// The first song is up next until it can play, so listeners see
+		// it buffering.
+		o.mu.Lock()
+		o.next = &SongState{
+			song: firstSong,
+		}
+		o.mu.Unlock()
+		o.broadcastQueue()
+
+		// Wait for first song to be ready
+		var err error
+		info, err = o.waitForDownload(firstSong.ID())
+		if err == nil {
+			break
+		}
+
+		// Start with the second song instead, it is already downloading.
+		fmt.Printf("Failed to download first song %s, skipping it: %v\n", firstSong.ID(), err)
+		firstSong, secondSong = secondSong, o.pickSong()
+		o.downloadService.QueueDownload(secondSong)
+		// Do not spin when every song fails straight away.
+		time.Sleep(time.Second)
 	}
 
 	// Initialize the current song state
 	now := time.Now()
 	o.mu.Lock()
-	o.current = &SongState{
-		song:      firstSong,
-		startTime: now,
-		endTime:   now.Add(time.Duration(info.Duration) * time.Second),
-		duration:  info.Duration,
-	}
+	o.current = newSongState(firstSong, info, now)
 	o.next = &SongState{
 		song: secondSong,
 	}
+	current := o.current
 	o.mu.Unlock()
 
 	// Broadcast initial state
+	o.playCurrentSong(info, nil)
 	o.broadcastCurrentSong()
-	return nil
+	o.broadcastQueue()
+
+	if o.crossfadeDuration > 0 {
+		go o.prepareCrossfade(current, secondSong)
+	}
 }
 
-func (o *Orchestrator) waitForDownload(id string) (*download.SongInfo, error) {
-	for attempts := 0; attempts < 60; attempts++ {
-		if info, exists := o.downloadService.GetDownload(id); exists {
-			return info, nil
+// pickSong returns the next song from the picker, waiting for the library to
+// get songs when there is nothing to pick.
+func (o *Orchestrator) pickSong() *ingest.Song {
+	for {
+		if song := o.pickerService.NextSong(); song != nil {
+			return song
 		}
-		time.Sleep(time.Second)
+
+		fmt.Printf("Station %s has no songs to pick, retrying in %v\n", o.name, pickRetryInterval)
+		time.Sleep(pickRetryInterval)
+	}
+}
+
+func (o *Orchestrator) waitForDownload(id string) (*download.SongInfo, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	info, err := o.downloadService.WaitForDownload(ctx, id)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return nil, fmt.Errorf("timeout waiting for download of song %s", id)
 	}
-	return nil, fmt.Errorf("timeout waiting for download of song %s", id)
+	return info, err
 }
 
 func (o *Orchestrator) runPlaybackLoop(ctx context.Context) {
@@ -104,7 +198,8 @@ func (o *Orchestrator) runPlaybackLoop(ctx context.Context) {
 			return
 		default:
 			o.mu.RLock()
-			if time.Now().After(o.current.endTime) {
+			// With a crossfade the next song starts while this one fades out.
+			if time.Now().After(o.current.endTime.Add(-o.current.fadeOut)) {
 				o.mu.RUnlock()
 				if err := o.transitionToNextSong(); err != nil {
 					fmt.Printf("Error transitioning to next song: %v\n", err)
@@ -115,8 +210,11 @@ func (o *Orchestrator) runPlaybackLoop(ctx context.Context) {
 				fmt.Println("Playing song:", o.current.song.Title)
 				fmt.Println("Next up:", o.next.song.Title)
 				fmt.Println("Elapsed:", time.Since(o.current.startTime))
+				untilEnd := time.Until(o.current.endTime.Add(-o.current.fadeOut))
 				o.mu.RUnlock()
-				time.Sleep(100 * time.Millisecond)
+				// Wake up exactly at the end of the song rather than on the
+				// next poll.
+				time.Sleep(min(100*time.Millisecond, untilEnd))
 			}
 		}
 	}
@@ -144,49 +242,368 @@ func (o *Orchestrator) transitionToNextSong() error {
 	// Ensure next song is downloaded
 	nextInfo, err := o.waitForDownload(o.next.song.ID())
 	if err != nil {
+		// Pick something else rather than waiting on the same song forever.
+		o.replaceNext()
 		return err
 	}
 
 	// Prepare the next-next song
-	nextNextSong := o.pickerService.NextSong()
+	nextNextSong := o.pickSong()
 	o.downloadService.QueueDownload(nextNextSong)
 
 	// Update state
 	now := time.Now()
 	o.mu.Lock()
-	o.current = &SongState{
-		song:      o.next.song,
-		startTime: now,
-		endTime:   now.Add(time.Duration(nextInfo.Duration) * time.Second),
-		duration:  nextInfo.Duration,
+	fade := o.crossfade
+	o.crossfade = nil
+	if fade != nil && (o.current.fadeOut == 0 || o.current.skipped || fade.to != o.next.song || !fade.matches(nextInfo)) {
+		os.Remove(fade.path)
+		fade = nil
+	}
+
+	// The finished song carries on under the fade into the next one.
+	endTime := now
+	if fade != nil {
+		endTime = now.Add(fade.fade)
 	}
+	finished := o.recordHistory(endTime)
+
+	o.current = newSongState(o.next.song, nextInfo, now)
 	o.next = &SongState{
 		song: nextNextSong,
 	}
+	o.skipVotes = make(map[string]bool)
+	current := o.current
+
+	previousMix := o.playingMix
+	o.playingMix = ""
+	if fade != nil {
+		o.playingMix = fade.path
+	}
 	o.mu.Unlock()
 
 	// Broadcast the change
+	o.playCurrentSong(nextInfo, fade)
 	o.broadcastCurrentSong()
+	o.broadcastQueue()
+
+	// The previous mix finished playing a whole song ago.
+	if previousMix != "" {
+		os.Remove(previousMix)
+	}
+
+	if o.crossfadeDuration > 0 {
+		go o.prepareCrossfade(current, nextNextSong)
+	}
+
+	o.persistPlay(finished)
 	return nil
 }
 
+// forwardDownloadProgress tells listeners how far the download of the next
+// song has got, so a stall waiting for it shows up as buffering.
+func (o *Orchestrator) forwardDownloadProgress(event events.Event) {
+	progress, ok := event.Payload.(download.Progress)
+	if !ok {
+		return
+	}
+
+	o.mu.RLock()
+	var song *ingest.Song
+	if o.next != nil && o.next.song.ID() == progress.ID {
+		song = o.next.song
+	}
+	o.mu.RUnlock()
+
+	if song == nil {
+		return
+	}
+
+	o.websocketController.Broadcast(&controller.Message{
+		Type:    controller.MessageTypeDownloadProgress,
+		Payload: controller.NewDownloadProgressPayload(progress, song),
+	})
+}
+
+// replaceNext picks a new next song after the current one failed to download.
+func (o *Orchestrator) replaceNext() {
+	song := o.pickSong()
+	o.downloadService.QueueDownload(song)
+
+	o.mu.Lock()
+	fmt.Printf("Replacing %s with %s\n", o.next.song.ID(), song.ID())
+	o.next = &SongState{
+		song: song,
+	}
+	o.mu.Unlock()
+	o.broadcastQueue()
+}
+
+func newSongState(song *ingest.Song, info *download.SongInfo, startTime time.Time) *SongState {
+	return &SongState{
+		song:      song,
+		startTime: startTime,
+		endTime:   startTime.Add(info.PlayDuration()),
+		duration:  info.PlayDuration(),
+		gain:      info.Gain,
+		cueIn:     info.CueIn,
+		info:      info,
+	}
+}
+
+// playCurrentSong switches the live stream over to the current song, starting
+// with the crossfade into it if there is one.
+func (o *Orchestrator) playCurrentSong(info *download.SongInfo, fade *crossfade) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	track := &stream.Track{
+		ID:        o.current.song.ID(),
+		Title:     streamTitle(o.current.song),
+		Path:      info.Path,
+		StartTime: o.current.startTime,
+		Gain:      o.current.gain,
+		CueIn:     info.CueIn,
+		CueOut:    info.CueOut,
+	}
+
+	if fade != nil {
+		// The mix already contains the start of the song.
+		track.CueIn += fade.fade
+		track.StartTime = track.StartTime.Add(fade.fade)
+
+		track = &stream.Track{
+			ID:        track.ID,
+			Title:     track.Title,
+			Path:      fade.path,
+			StartTime: o.current.startTime,
+			CueOut:    fade.fade,
+			Next:      track,
+		}
+	}
+
+	o.broadcaster.Play(track)
+	o.downloadService.MarkPlayed(o.current.song.ID())
+}
+
+// streamTitle formats a song as "Artist - Title" for stream metadata.
+func streamTitle(song *ingest.Song) string {
+	if song.Artist == "" {
+		return song.Title
+	}
+	return song.Artist + " - " + song.Title
+}
+
 func (o *Orchestrator) broadcastCurrentSong() {
 	o.mu.RLock()
 	defer o.mu.RUnlock()
 
 	message := &controller.Message{
-		Type: controller.MessageTypeCurrentSong,
-		Payload: &controller.CurrentSongPayload{
-			Title:     o.current.song.Title,
-			Artist:    o.current.song.Artist,
-			Duration:  o.current.duration,
-			ID:        o.current.song.ID(),
-			StartTime: o.current.startTime.Format(time.RFC3339),
-			EndTime:   o.current.endTime.Format(time.RFC3339),
-		},
+		Type:    controller.MessageTypeCurrentSong,
+		Payload: o.currentSongPayload(),
 	}
 
 	o.websocketController.Broadcast(message)
 	o.websocketController.BroadcastOnNewClient(message)
 }
 
+// currentSongPayload describes the current song. The caller must hold o.mu.
+func (o *Orchestrator) currentSongPayload() *controller.CurrentSongPayload {
+	var submitter *controller.SubmitterPayload
+	if o.current.song.Submitter != nil {
+		submitter = controller.NewSubmitterPayload(*o.current.song.Submitter)
+	}
+
+	return &controller.CurrentSongPayload{
+		Title:      o.current.song.Title,
+		Artist:     o.current.song.Artist,
+		Album:      o.current.song.Album,
+		ArtUrl:     o.current.song.ArtUrl,
+		Duration:   int(o.current.duration.Round(time.Second) / time.Second),
+		DurationMs: o.current.duration.Milliseconds(),
+		GainDB:     o.current.gain,
+		CueInMs:    o.current.cueIn.Milliseconds(),
+		ID:         o.current.song.ID(),
+		StartTime:  o.current.startTime.Format(controller.TimeFormat),
+		EndTime:    o.current.endTime.Format(controller.TimeFormat),
+		Submitter:  submitter,
+	}
+}
+
+// recordHistory moves the current song into the history, marking it as
+// finished at endTime, and returns the finished state. The caller must hold o.mu.
+func (o *Orchestrator) recordHistory(endTime time.Time) *SongState {
+	if o.current == nil {
+		return nil
+	}
+
+	finished := *o.current
+	finished.endTime = endTime
+	finished.listeners = o.listenerCount()
+
+	o.history = append(o.history, &finished)
+	if len(o.history) > historySize {
+		o.history = o.history[len(o.history)-historySize:]
+	}
+
+	return &finished
+}
+
+// persistPlay writes a finished song to the history store, if there is one.
+func (o *Orchestrator) persistPlay(state *SongState) {
+	if o.historyStore == nil || state == nil {
+		return
+	}
+
+	play := &history.Play{
+		Station:   o.name,
+		SongID:    state.song.ID(),
+		Artist:    state.song.Artist,
+		Title:     state.song.Title,
+		StartTime: state.startTime,
+		EndTime:   state.endTime,
+		Listeners: state.listeners,
+		Skipped:   state.skipped,
+	}
+
+	if state.song.Submitter != nil {
+		play.Submitter = state.song.Submitter.Name
+	}
+
+	if err := o.historyStore.RecordPlay(play); err != nil {
+		fmt.Printf("Failed to record play of %s: %v\n", play.SongID, err)
+	}
+}
+
+// listenerCount returns the number of websocket and stream listeners.
+func (o *Orchestrator) listenerCount() int {
+	return o.websocketController.ClientCount() + o.broadcaster.ListenerCount()
+}
+
+// Name implements controller.Station.
+func (o *Orchestrator) Name() string {
+	return o.name
+}
+
+// Listeners implements controller.Station.
+func (o *Orchestrator) Listeners() int {
+	return o.listenerCount()
+}
+
+// Library implements controller.Station.
+func (o *Orchestrator) Library() []*ingest.Song {
+	return o.pickerService.Songs()
+}
+
+// NowPlaying implements controller.Station.
+func (o *Orchestrator) NowPlaying() *controller.NowPlayingPayload {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	if o.current == nil {
+		return nil
+	}
+
+	now := time.Now()
+	return &controller.NowPlayingPayload{
+		CurrentSongPayload: *o.currentSongPayload(),
+		Elapsed:            max(now.Sub(o.current.startTime), 0).Seconds(),
+		Remaining:          max(o.current.endTime.Sub(now), 0).Seconds(),
+	}
+}
+
+// UpNext implements controller.Station.
+func (o *Orchestrator) UpNext() *controller.SongPayload {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	if o.next == nil {
+		return nil
+	}
+
+	return controller.NewSongPayload(o.next.song)
+}
+
+// PinnedSongs implements download.PinnedSongs. The current and next songs, all
+// requested songs and the upcoming songs broadcast to clients are kept in the
+// cache.
+func (o *Orchestrator) PinnedSongs() []string {
+	var ids []string
+
+	o.mu.RLock()
+	if o.current != nil {
+		ids = append(ids, o.current.song.ID())
+	}
+	if o.next != nil {
+		ids = append(ids, o.next.song.ID())
+	}
+	o.mu.RUnlock()
+
+	for _, request := range o.pickerService.Requests().Pending() {
+		ids = append(ids, request.Song.ID())
+	}
+
+	// The songs listeners see coming up would otherwise be evicted only to
+	// be downloaded again minutes later.
+	for _, upcoming := range o.pickerService.Peek(queueLength - 1) {
+		ids = append(ids, upcoming.Song.ID())
+	}
+
+	return ids
+}
+
+// History implements controller.Station. Persisted history is preferred over
+// the in-memory history when a store is configured.
+func (o *Orchestrator) History(limit int) []*controller.HistoryEntryPayload {
+	if o.historyStore != nil {
+		plays, err := o.historyStore.Recent(o.name, limit)
+		if err == nil {
+			return o.playsToHistory(plays)
+		}
+		fmt.Printf("Failed to read play history: %v\n", err)
+	}
+
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	history := make([]*controller.HistoryEntryPayload, 0, min(limit, len(o.history)))
+	for i := len(o.history) - 1; i >= 0 && len(history) < limit; i-- {
+		state := o.history[i]
+		history = append(history, &controller.HistoryEntryPayload{
+			Song:      controller.NewSongPayload(state.song),
+			StartTime: state.startTime.Format(controller.TimeFormat),
+			EndTime:   state.endTime.Format(controller.TimeFormat),
+			Listeners: state.listeners,
+			Skipped:   state.skipped,
+		})
+	}
+
+	return history
+}
+
+func (o *Orchestrator) playsToHistory(plays []*history.Play) []*controller.HistoryEntryPayload {
+	entries := make([]*controller.HistoryEntryPayload, 0, len(plays))
+	for _, play := range plays {
+		// Prefer the library entry, but the song may have been removed since.
+		var song *controller.SongPayload
+		if librarySong := o.dataService.GetSong(play.SongID); librarySong != nil {
+			song = controller.NewSongPayload(librarySong)
+		} else {
+			song = &controller.SongPayload{
+				ID:     play.SongID,
+				Artist: play.Artist,
+				Title:  play.Title,
+			}
+		}
+
+		entries = append(entries, &controller.HistoryEntryPayload{
+			Song:      song,
+			StartTime: play.StartTime.Format(controller.TimeFormat),
+			EndTime:   play.EndTime.Format(controller.TimeFormat),
+			Listeners: play.Listeners,
+			Skipped:   play.Skipped,
+		})
+	}
+
+	return entries
+}
@@ -0,0 +1,56 @@
+package orchestrator
+
+import (
+	"github.com/feline-dis/go-radio/internal/controller"
+	"github.com/feline-dis/go-radio/internal/ingest"
+	"github.com/feline-dis/go-radio/internal/picker"
+)
+
+// queueLength is how many upcoming songs are sent to clients, including the
+// next song.
+const queueLength = 10
+
+// broadcastQueue sends the upcoming songs to all clients, and to clients as
+// they connect.
+func (o *Orchestrator) broadcastQueue() {
+	message := &controller.Message{
+		Type:    controller.MessageTypeQueue,
+		Payload: o.queuePayload(),
+	}
+
+	o.websocketController.Broadcast(message)
+	o.websocketController.BroadcastOnNewClient(message)
+}
+
+// queuePayload lists the next song followed by the songs the picker is going
+// to pick after it.
+func (o *Orchestrator) queuePayload() *controller.QueuePayload {
+	o.mu.RLock()
+	var next *ingest.Song
+	if o.next != nil {
+		next = o.next.song
+	}
+	o.mu.RUnlock()
+
+	songs := make([]*controller.QueueEntryPayload, 0, queueLength)
+	if next != nil {
+		songs = append(songs, o.queueEntry(next, nil))
+	}
+	for _, upcoming := range o.pickerService.Peek(queueLength - len(songs)) {
+		songs = append(songs, o.queueEntry(upcoming.Song, upcoming.Request))
+	}
+
+	return &controller.QueuePayload{Songs: songs}
+}
+
+func (o *Orchestrator) queueEntry(song *ingest.Song, request *picker.Request) *controller.QueueEntryPayload {
+	entry := &controller.QueueEntryPayload{
+		Song: controller.NewSongPayload(song),
+	}
+
+	if request != nil {
+		entry.RequestedBy = request.Name
+	}
+
+	return entry
+}
@@ -0,0 +1,98 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/feline-dis/go-radio/internal/controller"
+)
+
+// SkipThreshold is how many votes end the current song early. Votes takes
+// precedence when set, otherwise Percent of connected listeners is required.
+type SkipThreshold struct {
+	Votes   int
+	Percent float64
+}
+
+var DefaultSkipThreshold = SkipThreshold{
+	Percent: 50,
+}
+
+// required returns the number of votes needed with the given number of listeners.
+func (t SkipThreshold) required(listeners int) int {
+	if t.Votes > 0 {
+		return t.Votes
+	}
+
+	return max(int(math.Ceil(t.Percent/100*float64(listeners))), 1)
+}
+
+var (
+	errStaleSkipVote  = errors.New("that song is no longer playing")
+	errNothingPlaying = errors.New("nothing is playing")
+)
+
+// handleVoteSkip records a client's vote to skip the current song and skips
+// it once the threshold is reached.
+func (o *Orchestrator) handleVoteSkip(client *controller.Client, raw json.RawMessage) (*controller.Message, error) {
+	var payload controller.VoteSkipPayload
+	if len(raw) > 0 && string(raw) != "null" {
+		if err := json.Unmarshal(raw, &payload); err != nil {
+			return nil, controller.InvalidPayload(controller.MessageTypeVoteSkip)
+		}
+	}
+
+	listeners := o.websocketController.AddressCount()
+
+	o.mu.Lock()
+	if o.current == nil {
+		o.mu.Unlock()
+		return nil, errNothingPlaying
+	}
+
+	songID := o.current.song.ID()
+	if payload.SongID != "" && payload.SongID != songID {
+		o.mu.Unlock()
+		return nil, errStaleSkipVote
+	}
+
+	o.skipVotes[client.RemoteAddr] = true
+
+	// Listeners who left since voting no longer count.
+	votes := 0
+	for address := range o.skipVotes {
+		if o.websocketController.IsConnected(address) {
+			votes++
+		}
+	}
+	required := o.skipThreshold.required(listeners)
+	skip := votes >= required && !o.current.skipped
+	if skip {
+		// The playback loop transitions as soon as the song has ended.
+		o.current.skipped = true
+		o.current.endTime = time.Now()
+		o.current.fadeOut = 0
+	}
+	o.mu.Unlock()
+
+	if skip {
+		fmt.Printf("Skipping %s after %d/%d votes\n", songID, votes, required)
+	}
+
+	// The voter sees the tally in the broadcast, so the vote is just acked.
+	o.websocketController.Broadcast(&controller.Message{
+		Type: controller.MessageTypeSkipVotes,
+		Payload: &controller.SkipVotesPayload{
+			SongID:    songID,
+			Votes:     votes,
+			Required:  required,
+			Listeners: listeners,
+			Skipped:   skip,
+		},
+	})
+
+	return nil, nil
+}
@@ -0,0 +1,155 @@
+package picker
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/feline-dis/go-radio/internal/ingest"
+)
+
+// ShareMode decides how much of the rotation each submitter gets.
+type ShareMode string
+
+const (
+	// ShareEqual gives every submitter the same number of plays, repeating
+	// the songs of submitters with small lists.
+	ShareEqual ShareMode = "equal"
+	// ShareProportional plays every song once, spreading each submitter's
+	// songs evenly through the rotation.
+	ShareProportional ShareMode = "proportional"
+	// ShareWeighted gives submitters plays in proportion to their weights.
+	ShareWeighted ShareMode = "weighted"
+)
+
+// FairShare shuffles songs so submitters take turns instead of the largest
+// list dominating the rotation. Each submitter's songs are shuffled with
+// SpotifyShuffle, so artists stay spread apart.
+type FairShare struct {
+	Mode ShareMode `json:"mode"`
+	// Weights are the shares of submitters by name in weighted mode.
+	// Submitters without a weight get 1.
+	Weights map[string]float64 `json:"weights"`
+}
+
+// Validate checks that the mode is known and the weights are positive.
+func (fs *FairShare) Validate() error {
+	switch fs.Mode {
+	case ShareEqual, ShareProportional, ShareWeighted:
+	default:
+		return fmt.Errorf("unknown share mode %q", fs.Mode)
+	}
+
+	for name, weight := range fs.Weights {
+		if weight <= 0 {
+			return fmt.Errorf("weight of %q must be positive", name)
+		}
+	}
+
+	return nil
+}
+
+// submitterTurn tracks a submitter's place in a fair share rotation.
+type submitterTurn struct {
+	songs  []*ingest.Song
+	next   int
+	weight float64
+	// credit is how far the submitter is behind its share.
+	credit float64
+}
+
+// Shuffle implements Strategy. The rotation is as long as songs, but in equal
+// and weighted mode it may repeat some songs and leave out others.
+func (fs *FairShare) Shuffle(songs []*ingest.Song) []*ingest.Song {
+	bySubmitter := make(map[string][]*ingest.Song)
+	for _, song := range songs {
+		name := ""
+		if song.Submitter != nil {
+			name = song.Submitter.Name
+		}
+		bySubmitter[name] = append(bySubmitter[name], song)
+	}
+
+	// Sorted so the rotation only depends on the shuffles.
+	names := make([]string, 0, len(bySubmitter))
+	for name := range bySubmitter {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	turns := make([]*submitterTurn, 0, len(names))
+	total := 0.0
+	for _, name := range names {
+		turn := &submitterTurn{
+			songs:  SpotifyShuffle(bySubmitter[name]),
+			weight: fs.weight(name, len(bySubmitter[name])),
+		}
+		turns = append(turns, turn)
+		total += turn.weight
+	}
+
+	// Smooth weighted round robin: every submitter earns its weight each
+	// turn and the one furthest behind plays, which interleaves submitters
+	// as evenly as their shares allow.
+	result := make([]*ingest.Song, 0, len(songs))
+	var last *ingest.Song
+	for len(result) < len(songs) {
+		var turn *submitterTurn
+		for _, candidate := range turns {
+			candidate.credit += candidate.weight
+			if turn == nil || candidate.credit > turn.credit {
+				turn = candidate
+			}
+		}
+		turn.credit -= total
+
+		last = turn.pick(last)
+		result = append(result, last)
+	}
+
+	return result
+}
+
+// weight returns the share of the submitter with the given name and number of
+// songs.
+func (fs *FairShare) weight(name string, songs int) float64 {
+	switch fs.Mode {
+	case ShareProportional:
+		return float64(songs)
+	case ShareWeighted:
+		if weight, exists := fs.Weights[name]; exists {
+			return weight
+		}
+	}
+
+	return 1
+}
+
+// pick returns the submitter's next song, preferring one by a different artist
+// than previous. The songs are reshuffled once they have all played.
+func (st *submitterTurn) pick(previous *ingest.Song) *ingest.Song {
+	if st.next == len(st.songs) {
+		st.songs = SpotifyShuffle(st.songs)
+		st.next = 0
+	}
+
+	// Swap in the closest song by another artist if this one would play the
+	// same artist twice in a row, as another submitter may have just played
+	// them.
+	if previous != nil && sameArtist(st.songs[st.next], previous) {
+		for i := st.next + 1; i < len(st.songs); i++ {
+			if !sameArtist(st.songs[i], previous) {
+				st.songs[st.next], st.songs[i] = st.songs[i], st.songs[st.next]
+				break
+			}
+		}
+	}
+
+	song := st.songs[st.next]
+	st.next++
+	return song
+}
+
+func sameArtist(a, b *ingest.Song) bool {
+	return strings.EqualFold(a.Artist, b.Artist)
+}
@@ -0,0 +1,181 @@
+package picker
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/feline-dis/go-radio/internal/ingest"
+)
+
+// library returns songs for each submitter, spread over a few artists.
+func library(sizes map[string]int) []*ingest.Song {
+	var songs []*ingest.Song
+	for name, size := range sizes {
+		submitter := &ingest.Submitter{Name: name}
+		for i := 0; i < size; i++ {
+			songs = append(songs, &ingest.Song{
+				Artist:    fmt.Sprintf("%s artist %d", name, i%5),
+				Title:     fmt.Sprintf("%s song %d", name, i),
+				Submitter: submitter,
+			})
+		}
+	}
+	return songs
+}
+
+func TestFairShareShares(t *testing.T) {
+	sizes := map[string]int{"big": 300, "small": 20, "tiny": 4}
+	total := 324
+
+	tests := []struct {
+		name  string
+		share *FairShare
+		// want is each submitter's expected number of plays.
+		want map[string]int
+	}{
+		{
+			name:  "equal",
+			share: &FairShare{Mode: ShareEqual},
+			want:  map[string]int{"big": 108, "small": 108, "tiny": 108},
+		},
+		{
+			name:  "proportional",
+			share: &FairShare{Mode: ShareProportional},
+			want:  sizes,
+		},
+		{
+			name:  "weighted",
+			share: &FairShare{Mode: ShareWeighted, Weights: map[string]float64{"big": 1, "small": 2}},
+			// tiny has no weight and gets 1.
+			want: map[string]int{"big": 81, "small": 162, "tiny": 81},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			songs := tt.share.Shuffle(library(sizes))
+			if len(songs) != total {
+				t.Fatalf("rotation has %d songs, want %d", len(songs), total)
+			}
+
+			plays := make(map[string]int)
+			for _, song := range songs {
+				plays[song.Submitter.Name]++
+			}
+			for name, want := range tt.want {
+				if plays[name] != want {
+					t.Errorf("%s played %d times, want %d", name, plays[name], want)
+				}
+			}
+		})
+	}
+}
+
+func TestFairShareInterleaves(t *testing.T) {
+	sizes := map[string]int{"big": 300, "small": 20}
+
+	for _, mode := range []ShareMode{ShareEqual, ShareProportional, ShareWeighted} {
+		t.Run(string(mode), func(t *testing.T) {
+			share := &FairShare{Mode: mode}
+			songs := share.Shuffle(library(sizes))
+
+			// The gap between plays of a submitter never exceeds its
+			// share by more than one slot.
+			plays := make(map[string]int)
+			for _, song := range songs {
+				plays[song.Submitter.Name]++
+			}
+			for name := range sizes {
+				maxGap := int(math.Ceil(float64(len(songs))/float64(plays[name]))) + 1
+				last := -1
+				for i, song := range songs {
+					if song.Submitter.Name != name {
+						continue
+					}
+					if last >= 0 && i-last > maxGap {
+						t.Errorf("%s waited %d songs between plays, want at most %d", name, i-last, maxGap)
+					}
+					last = i
+				}
+			}
+		})
+	}
+}
+
+func TestFairShareSpreadsArtists(t *testing.T) {
+	// Submitters share artists, so one submitter often has to pick around
+	// the artist another just played.
+	songs := library(map[string]int{"a": 40, "b": 12, "c": 8})
+	for i, song := range songs {
+		song.Artist = fmt.Sprintf("artist %d", i%6)
+	}
+	songs = (&FairShare{Mode: ShareProportional}).Shuffle(songs)
+
+	// Every song plays once, so an artist may only play twice in a row when
+	// the submitter has nothing else left.
+	for i := 1; i < len(songs); i++ {
+		if !sameArtist(songs[i-1], songs[i]) {
+			continue
+		}
+		for _, later := range songs[i+1:] {
+			if later.Submitter == songs[i].Submitter && !sameArtist(later, songs[i]) {
+				t.Errorf("%q played twice in a row at %d", songs[i].Artist, i)
+				break
+			}
+		}
+	}
+}
+
+func TestFairShareProportionalPlaysEverySong(t *testing.T) {
+	songs := library(map[string]int{"a": 40, "b": 9, "c": 1})
+	shuffled := (&FairShare{Mode: ShareProportional}).Shuffle(songs)
+
+	seen := make(map[*ingest.Song]bool)
+	for _, song := range shuffled {
+		if seen[song] {
+			t.Fatalf("%s played twice", song.Title)
+		}
+		seen[song] = true
+	}
+	if len(seen) != len(songs) {
+		t.Errorf("played %d songs, want %d", len(seen), len(songs))
+	}
+}
+
+func TestFairShareWithoutSubmitter(t *testing.T) {
+	songs := append(library(map[string]int{"a": 6}), &ingest.Song{Artist: "x", Title: "unattributed"})
+
+	shuffled := (&FairShare{Mode: ShareEqual}).Shuffle(songs)
+	if len(shuffled) != len(songs) {
+		t.Fatalf("rotation has %d songs, want %d", len(shuffled), len(songs))
+	}
+
+	if got := (&FairShare{Mode: ShareEqual}).Shuffle(nil); len(got) != 0 {
+		t.Errorf("Shuffle(nil) = %d songs, want none", len(got))
+	}
+}
+
+func TestFairShareValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		share   FairShare
+		wantErr bool
+	}{
+		{"equal", FairShare{Mode: ShareEqual}, false},
+		{"proportional", FairShare{Mode: ShareProportional}, false},
+		{"weighted", FairShare{Mode: ShareWeighted, Weights: map[string]float64{"a": 0.5}}, false},
+		{"unknown mode", FairShare{Mode: "random"}, true},
+		{"missing mode", FairShare{}, true},
+		{"zero weight", FairShare{Mode: ShareWeighted, Weights: map[string]float64{"a": 0}}, true},
+		{"negative weight", FairShare{Mode: ShareWeighted, Weights: map[string]float64{"a": -1}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.share.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"math/rand"
 	"sort"
 	"strings"
+	"sync"
 
 	"github.com/feline-dis/go-radio/internal/ingest"
 )
@@ -14,63 +15,188 @@ type songWithPosition struct {
 	position float64
 }
 
+// Availability reports songs that can no longer be played.
+type Availability interface {
+	IsUnavailable(id string) bool
+}
+
+// Strategy orders songs for one rotation of the picker. It may leave songs out
+// or repeat them; every song gets another chance in the next rotation.
+type Strategy func(songs []*ingest.Song) []*ingest.Song
+
+// Upcoming is a song the picker is going to pick.
+type Upcoming struct {
+	Song *ingest.Song
+	// Request is set if a listener requested the song.
+	Request *Request
+}
+
 type PickerService struct {
-	dataService *ingest.DataService
-	AllSongs    []*ingest.Song
-	queue       []*ingest.Song
-	unpicked    []*ingest.Song
-	quePos      int
+	dataService    *ingest.DataService
+	submitters     map[string]bool
+	strategy       Strategy
+	requests       *RequestQueue
+	AllSongs       []*ingest.Song
+	queue          []*ingest.Song
+	unpicked       []*ingest.Song
+	quePos         int
+	availability   Availability
+	changeHandlers []func()
+	mu             sync.Mutex
 }
 
-func NewPickerService(ds *ingest.DataService) *PickerService {
-	allSongs := SpotifyShuffle(ds.Songs)
+// NewPickerService creates a picker over the songs in ds that plays listener
+// requests first and orders the rest with strategy, or SpotifyShuffle if it is
+// nil. When submitters are given, only songs from those submitters are picked.
+func NewPickerService(ds *ingest.DataService, requests *RequestQueue, strategy Strategy, submitters ...string) *PickerService {
+	filter := make(map[string]bool, len(submitters))
+	for _, name := range submitters {
+		filter[name] = true
+	}
+
+	if strategy == nil {
+		strategy = SpotifyShuffle
+	}
 
-	// Calculate queue size (2/3 of total songs)
-	queueSize := 2 * (len(allSongs) / 3)
+	allSongs := filterSongs(ds, filter)
+	shuffled := strategy(allSongs)
+
+	queueSize := queueSize(len(shuffled))
 
 	// Create the service
 	ps := &PickerService{
 		AllSongs:    allSongs,
 		dataService: ds,
+		submitters:  filter,
+		strategy:    strategy,
+		requests:    requests,
 		queue:       make([]*ingest.Song, queueSize),
-		unpicked:    make([]*ingest.Song, len(allSongs)-queueSize),
+		unpicked:    make([]*ingest.Song, len(shuffled)-queueSize),
 		quePos:      0,
 	}
 
-	copy(ps.queue, allSongs[:queueSize])
+	copy(ps.queue, shuffled[:queueSize])
 
-	copy(ps.unpicked, allSongs[queueSize:])
+	copy(ps.unpicked, shuffled[queueSize:])
+
+	requests.OnChange(ps.notifyChange)
 
 	return ps
 }
 
+// Requests returns the listener request queue.
+func (ps *PickerService) Requests() *RequestQueue {
+	return ps.requests
+}
+
+// Songs returns every song the picker can pick.
+func (ps *PickerService) Songs() []*ingest.Song {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	songs := make([]*ingest.Song, len(ps.AllSongs))
+	copy(songs, ps.AllSongs)
+	return songs
+}
+
+// SetAvailability makes the picker skip songs a reports as unavailable. It
+// must be called before the picker is used.
+func (ps *PickerService) SetAvailability(a Availability) {
+	ps.availability = a
+}
+
+// NextSong picks the song to play next. It returns nil if there is nothing to
+// pick, e.g. because every song was removed from the library.
 func (ps *PickerService) NextSong() *ingest.Song {
-	fmt.Println("quePos: ", ps.quePos)
-	fmt.Println("len(ps.queue): ", len(ps.queue))
-	if ps.quePos >= len(ps.queue) {
-		ps.ShuffleQueue()
-		ps.quePos = 0
+	for request := ps.requests.Pop(); request != nil; request = ps.requests.Pop() {
+		if ps.isUnavailable(request.Song) {
+			fmt.Println("Skipping unavailable request", request.Song.ID())
+			continue
+		}
+		fmt.Println("Playing request from", request.Requester)
+		return request.Song
 	}
 
-	song := ps.queue[ps.quePos]
-	ps.quePos++
-	return song
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	// Stop skipping once every song was tried so the picker never loops
+	// forever when nothing is available.
+	for tries := 0; ; tries++ {
+		if ps.quePos >= len(ps.queue) {
+			ps.shuffleQueue()
+			ps.quePos = 0
+		}
+		if len(ps.queue) == 0 {
+			return nil
+		}
+
+		song := ps.queue[ps.quePos]
+		ps.quePos++
+
+		if tries >= len(ps.AllSongs) || !ps.isUnavailable(song) {
+			return song
+		}
+		fmt.Println("Skipping unavailable song", song.ID())
+	}
 }
 
-func (ps *PickerService) ShuffleQueue() {
-	fmt.Println("Shuffling queue...")
+// Peek returns up to n songs NextSong is going to pick, in order, without
+// picking them. It does not look past the end of the shuffled queue, which is
+// reshuffled once it is reached.
+func (ps *PickerService) Peek(n int) []Upcoming {
+	upcoming := make([]Upcoming, 0, n)
 
-	// Combine current queue and unpicked songs
-	allSongs := make([]*ingest.Song, len(ps.queue)+len(ps.unpicked))
-	copy(allSongs, ps.queue)
-	copy(allSongs[len(ps.queue):], ps.unpicked)
+	for _, request := range ps.requests.Pending() {
+		if len(upcoming) == n {
+			return upcoming
+		}
+		if !ps.isUnavailable(request.Song) {
+			upcoming = append(upcoming, Upcoming{Song: request.Song, Request: request})
+		}
+	}
 
-	// Shuffle all songs
-	shuffled := SpotifyShuffle(allSongs)
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	for i := ps.quePos; i < len(ps.queue) && len(upcoming) < n; i++ {
+		if !ps.isUnavailable(ps.queue[i]) {
+			upcoming = append(upcoming, Upcoming{Song: ps.queue[i]})
+		}
+	}
+
+	return upcoming
+}
+
+// OnChange registers a handler that is called when the upcoming songs change
+// other than by picking one, i.e. when a song is requested or the library
+// changes. It must be called before the picker is used.
+func (ps *PickerService) OnChange(handler func()) {
+	ps.changeHandlers = append(ps.changeHandlers, handler)
+}
+
+func (ps *PickerService) notifyChange() {
+	for _, handler := range ps.changeHandlers {
+		handler()
+	}
+}
+
+// isUnavailable reports whether song can no longer be played.
+func (ps *PickerService) isUnavailable(song *ingest.Song) bool {
+	return ps.availability != nil && ps.availability.IsUnavailable(song.ID())
+}
+
+// shuffleQueue starts a new rotation. The caller must hold ps.mu.
+func (ps *PickerService) shuffleQueue() {
+	fmt.Println("Shuffling queue...")
+
+	// Shuffle all songs. The queue may repeat or miss songs depending on
+	// the strategy, so start over from the library.
+	shuffled := ps.strategy(ps.AllSongs)
 
 	// Calculate new sizes (maintaining original ratio)
 	totalSize := len(shuffled)
-	queueSize := 2 * (totalSize / 3) // Same ratio as in SyncData
+	queueSize := queueSize(totalSize)
 
 	// Create new queue and unpicked slices
 	ps.queue = make([]*ingest.Song, queueSize)
@@ -81,18 +207,94 @@ func (ps *PickerService) ShuffleQueue() {
 	copy(ps.unpicked, shuffled[queueSize:])
 }
 
+// queueSize returns how many of total shuffled songs are queued: 2/3 of them,
+// but at least one so small libraries still play.
+func queueSize(total int) int {
+	return max(2*(total/3), min(total, 1))
+}
+
+// SyncData brings the picker up to date with the data service. Songs that were
+// removed are dropped from the queue and the part of the rotation that has not
+// played yet is shuffled again together with the new songs, so the current
+// rotation carries on with them.
 func (ps *PickerService) SyncData() {
-	ps.AllSongs = SpotifyShuffle(ps.dataService.Songs)
+	songs := filterSongs(ps.dataService, ps.submitters)
 
-	queueSize := 2 * (len(ps.AllSongs) / 3)
+	// Deferred first so handlers run after the lock is released.
+	defer ps.notifyChange()
 
-	ps.queue = make([]*ingest.Song, queueSize)
-	ps.unpicked = make([]*ingest.Song, len(ps.AllSongs)-queueSize)
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	current := make(map[string]*ingest.Song, len(songs))
+	for _, song := range songs {
+		current[song.ID()] = song
+	}
+
+	known := make(map[string]bool, len(ps.AllSongs))
+	for _, song := range ps.AllSongs {
+		known[song.ID()] = true
+	}
+
+	// Drop removed songs, keeping quePos pointing at the same next song.
+	queue := make([]*ingest.Song, 0, len(ps.queue))
+	quePos := ps.quePos
+	for i, song := range ps.queue {
+		updated, exists := current[song.ID()]
+		if !exists {
+			if i < ps.quePos {
+				quePos--
+			}
+			continue
+		}
+		queue = append(queue, updated)
+	}
+
+	unpicked := make([]*ingest.Song, 0, len(ps.unpicked))
+	for _, song := range ps.unpicked {
+		if updated, exists := current[song.ID()]; exists {
+			unpicked = append(unpicked, updated)
+		}
+	}
+
+	// Copied so the strategy does not touch the played part of the queue.
+	upcoming := append([]*ingest.Song(nil), queue[quePos:]...)
+	added := 0
+	for _, song := range songs {
+		if known[song.ID()] {
+			continue
+		}
+		added++
+		upcoming = append(upcoming, song)
+	}
+	if added > 0 {
+		queue = append(queue[:quePos], ps.strategy(upcoming)...)
+	}
+
+	ps.AllSongs = songs
+	ps.queue = queue
+	ps.unpicked = unpicked
+	ps.quePos = quePos
 
-	copy(ps.queue, SpotifyShuffle(ps.AllSongs[:queueSize]))
-	copy(ps.unpicked, ps.AllSongs[queueSize:])
+	fmt.Printf("picker synced: %d songs, %d new\n", len(songs), added)
+}
+
+// filterSongs returns the songs in ds submitted by one of submitters, or all
+// songs if submitters is empty.
+func filterSongs(ds *ingest.DataService, submitters map[string]bool) []*ingest.Song {
+	songs := ds.GetSongs()
+	if len(submitters) == 0 {
+		return songs
+	}
+
+	filtered := make([]*ingest.Song, 0, len(songs))
+	for _, song := range songs {
+		if song.Submitter != nil && submitters[song.Submitter.Name] {
+			filtered = append(filtered, song)
+		}
+	}
 
-	ps.quePos = 0
+	return filtered
 }
 
 func FisherYatesShuffle(list []*ingest.Song) {
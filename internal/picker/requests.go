@@ -0,0 +1,191 @@
+package picker
+
+import (
+	"container/heap"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/feline-dis/go-radio/internal/ingest"
+)
+
+var (
+	ErrRateLimited      = errors.New("too many requests, try again later")
+	ErrTooManyPending   = errors.New("you already have too many songs in the request queue")
+	ErrRequestQueueFull = errors.New("the request queue is full")
+	ErrAlreadyRequested = errors.New("this song has already been requested")
+)
+
+// RequestLimits bounds how much of the air a single listener can take.
+type RequestLimits struct {
+	// PerUser is the number of requests a listener may make per Window.
+	PerUser int
+	Window  time.Duration
+	// MaxPending is the number of requests a listener may have queued at once.
+	MaxPending int
+	// MaxQueue is the total number of requests that may be queued.
+	MaxQueue int
+}
+
+var DefaultRequestLimits = RequestLimits{
+	PerUser:    3,
+	Window:     30 * time.Minute,
+	MaxPending: 2,
+	MaxQueue:   20,
+}
+
+// Request is a song a listener asked to hear.
+type Request struct {
+	Song *ingest.Song
+	// Requester identifies the listener for rate limiting.
+	Requester string
+	// Name is the name the listener wants to be credited with.
+	Name        string
+	RequestedAt time.Time
+
+	// round is how many requests the requester already had queued when this
+	// one was made. Lower rounds play first so listeners take turns.
+	round    int
+	sequence int
+}
+
+// RequestQueue is a priority queue of listener requests that the picker plays
+// before its shuffled queue.
+type RequestQueue struct {
+	limits         RequestLimits
+	queue          requestHeap
+	recent         map[string][]time.Time
+	sequence       int
+	changeHandlers []func()
+	mu             sync.Mutex
+}
+
+// NewRequestQueue creates an empty request queue.
+func NewRequestQueue(limits RequestLimits) *RequestQueue {
+	return &RequestQueue{
+		limits: limits,
+		recent: make(map[string][]time.Time),
+	}
+}
+
+// OnChange registers a handler that is called after a request is queued. It
+// must be called before the queue is used.
+func (rq *RequestQueue) OnChange(handler func()) {
+	rq.changeHandlers = append(rq.changeHandlers, handler)
+}
+
+// Submit validates a request against the limits and queues it.
+func (rq *RequestQueue) Submit(request *Request) error {
+	if err := rq.submit(request); err != nil {
+		return err
+	}
+
+	for _, handler := range rq.changeHandlers {
+		handler()
+	}
+	return nil
+}
+
+func (rq *RequestQueue) submit(request *Request) error {
+	rq.mu.Lock()
+	defer rq.mu.Unlock()
+
+	now := time.Now()
+
+	if len(rq.queue) >= rq.limits.MaxQueue {
+		return ErrRequestQueueFull
+	}
+
+	pending := 0
+	for _, queued := range rq.queue {
+		if queued.Song.ID() == request.Song.ID() {
+			return ErrAlreadyRequested
+		}
+		if queued.Requester == request.Requester {
+			pending++
+		}
+	}
+
+	if pending >= rq.limits.MaxPending {
+		return ErrTooManyPending
+	}
+
+	// Forget requests that have left the rate limit window.
+	recent := rq.recent[request.Requester][:0]
+	for _, t := range rq.recent[request.Requester] {
+		if now.Sub(t) < rq.limits.Window {
+			recent = append(recent, t)
+		}
+	}
+
+	if len(recent) >= rq.limits.PerUser {
+		rq.recent[request.Requester] = recent
+		return ErrRateLimited
+	}
+
+	rq.recent[request.Requester] = append(recent, now)
+
+	request.RequestedAt = now
+	request.round = pending
+	request.sequence = rq.sequence
+	rq.sequence++
+
+	heap.Push(&rq.queue, request)
+	return nil
+}
+
+// Pop removes and returns the next request or nil if there are none.
+func (rq *RequestQueue) Pop() *Request {
+	rq.mu.Lock()
+	defer rq.mu.Unlock()
+
+	if len(rq.queue) == 0 {
+		return nil
+	}
+
+	return heap.Pop(&rq.queue).(*Request)
+}
+
+// Pending returns the queued requests in the order they will play.
+func (rq *RequestQueue) Pending() []*Request {
+	rq.mu.Lock()
+	defer rq.mu.Unlock()
+
+	pending := make(requestHeap, len(rq.queue))
+	copy(pending, rq.queue)
+	sort.Sort(pending)
+
+	return pending
+}
+
+// requestHeap implements heap.Interface ordered by round, then by arrival.
+type requestHeap []*Request
+
+func (h requestHeap) Len() int {
+	return len(h)
+}
+
+func (h requestHeap) Less(i, j int) bool {
+	if h[i].round != h[j].round {
+		return h[i].round < h[j].round
+	}
+	return h[i].sequence < h[j].sequence
+}
+
+func (h requestHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+}
+
+func (h *requestHeap) Push(x interface{}) {
+	*h = append(*h, x.(*Request))
+}
+
+func (h *requestHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	request := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return request
+}
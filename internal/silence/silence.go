@@ -0,0 +1,121 @@
+package silence
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+const (
+	// Threshold is the level below which audio counts as silence.
+	Threshold = "-50dB"
+	// MinSilence is the shortest gap that counts as silence.
+	MinSilence = 500 * time.Millisecond
+	// minPlayable is the shortest a song may be after trimming. Songs that
+	// would be shorter are not trimmed.
+	minPlayable = 5 * time.Second
+)
+
+// CuePoints are the positions in a track, in seconds, where its audio starts
+// and ends once leading and trailing silence are skipped.
+type CuePoints struct {
+	In  float64 `json:"in"`
+	Out float64 `json:"out"`
+}
+
+// InDuration returns the cue-in point as a duration.
+func (c *CuePoints) InDuration() time.Duration {
+	return seconds(c.In)
+}
+
+// OutDuration returns the cue-out point as a duration.
+func (c *CuePoints) OutDuration() time.Duration {
+	return seconds(c.Out)
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(math.Round(s*1000)) * time.Millisecond
+}
+
+var (
+	silenceStart = regexp.MustCompile(`silence_start: (-?[0-9.]+)`)
+	silenceEnd   = regexp.MustCompile(`silence_end: (-?[0-9.]+)`)
+)
+
+type span struct {
+	start, end float64
+}
+
+// Filter is the ffmpeg audio filter that detects silence. Its log is read from
+// ffmpeg's output with Parse.
+var Filter = fmt.Sprintf("silencedetect=noise=%s:d=%g", Threshold, MinSilence.Seconds())
+
+// Parse returns the cue points of a track that is duration long from the
+// output of an ffmpeg run with Filter.
+func Parse(output []byte, duration time.Duration) *CuePoints {
+	return cuePoints(parse(string(output)), duration)
+}
+
+// parse reads silencedetect's log lines into spans. A span without an end
+// lasts until the end of the file.
+func parse(output string) []span {
+	var spans []span
+
+	starts := silenceStart.FindAllStringSubmatchIndex(output, -1)
+	for i, match := range starts {
+		start, err := strconv.ParseFloat(output[match[2]:match[3]], 64)
+		if err != nil {
+			continue
+		}
+
+		// The end is logged before the next start.
+		rest := output[match[1]:]
+		if i+1 < len(starts) {
+			rest = output[match[1]:starts[i+1][0]]
+		}
+
+		end := math.Inf(1)
+		if m := silenceEnd.FindStringSubmatch(rest); m != nil {
+			if v, err := strconv.ParseFloat(m[1], 64); err == nil {
+				end = v
+			}
+		}
+
+		spans = append(spans, span{start: max(start, 0), end: end})
+	}
+
+	return spans
+}
+
+// cuePoints turns the silence at the very start and end of a track into cue
+// points.
+func cuePoints(spans []span, duration time.Duration) *CuePoints {
+	total := duration.Seconds()
+	cue := &CuePoints{Out: total}
+
+	// Allow for the encoder delay when deciding whether silence touches an
+	// edge of the track.
+	const edge = 0.1
+
+	if len(spans) == 0 {
+		return cue
+	}
+
+	if first := spans[0]; first.start <= edge {
+		cue.In = math.Min(first.end, total)
+	}
+
+	if last := spans[len(spans)-1]; last.end >= total-edge && last.start > cue.In {
+		cue.Out = last.start
+	}
+
+	if seconds(cue.Out-cue.In) < minPlayable {
+		return &CuePoints{Out: total}
+	}
+
+	cue.In = math.Round(cue.In*1000) / 1000
+	cue.Out = math.Round(cue.Out*1000) / 1000
+	return cue
+}
@@ -0,0 +1,123 @@
+package silence
+
+import (
+	"testing"
+	"time"
+)
+
+// header is the start of ffmpeg's output for a run with Filter.
+const header = `Input #0, mp3, from 'song.mp3':
+  Duration: 00:03:22.00, start: 0.025057, bitrate: 128 kb/s
+  Stream #0:0: Audio: mp3, 44100 Hz, stereo, fltp, 128 kb/s
+Stream mapping:
+  Stream #0:0 -> #0:0 (mp3 (mp3float) -> pcm_s16le (native))
+Press [q] to stop, [?] for help
+`
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		output   string
+		duration time.Duration
+		want     CuePoints
+	}{
+		{
+			name: "leading and trailing silence",
+			output: header + `[silencedetect @ 0x5594e5c0] silence_start: 0
+[silencedetect @ 0x5594e5c0] silence_end: 1.50408 | silence_duration: 1.50408
+size=N/A time=00:03:22.00 bitrate=N/A speed= 410x
+[silencedetect @ 0x5594e5c0] silence_start: 198.734
+`,
+			duration: 202 * time.Second,
+			want:     CuePoints{In: 1.504, Out: 198.734},
+		},
+		{
+			name: "trailing silence ended at the end of the file",
+			output: header + `[silencedetect @ 0x5594e5c0] silence_start: 198.734
+[silencedetect @ 0x5594e5c0] silence_end: 201.95 | silence_duration: 3.216
+`,
+			duration: 202 * time.Second,
+			want:     CuePoints{In: 0, Out: 198.734},
+		},
+		{
+			name: "negative start from the encoder delay",
+			output: header + `[silencedetect @ 0x55] silence_start: -0.0250567
+[silencedetect @ 0x55] silence_end: 0.8 | silence_duration: 0.825057
+`,
+			duration: 202 * time.Second,
+			want:     CuePoints{In: 0.8, Out: 202},
+		},
+		{
+			name: "silence just after the start",
+			output: header + `[silencedetect @ 0x55] silence_start: 0.05
+[silencedetect @ 0x55] silence_end: 2 | silence_duration: 1.95
+`,
+			duration: 202 * time.Second,
+			want:     CuePoints{In: 2, Out: 202},
+		},
+		{
+			name: "silence in the middle only",
+			output: header + `[silencedetect @ 0x55] silence_start: 60
+[silencedetect @ 0x55] silence_end: 62.5 | silence_duration: 2.5
+[silencedetect @ 0x55] silence_start: 120
+[silencedetect @ 0x55] silence_end: 121 | silence_duration: 1
+`,
+			duration: 202 * time.Second,
+			want:     CuePoints{In: 0, Out: 202},
+		},
+		{
+			name:     "no silence",
+			output:   header,
+			duration: 202 * time.Second,
+			want:     CuePoints{In: 0, Out: 202},
+		},
+		{
+			name: "too short once trimmed",
+			output: header + `[silencedetect @ 0x55] silence_start: 0
+[silencedetect @ 0x55] silence_end: 3 | silence_duration: 3
+`,
+			duration: 7 * time.Second,
+			want:     CuePoints{In: 0, Out: 7},
+		},
+		{
+			name:     "silent track",
+			output:   header + "[silencedetect @ 0x55] silence_start: 0\n",
+			duration: 202 * time.Second,
+			want:     CuePoints{In: 0, Out: 202},
+		},
+		{
+			name: "mixed with loudnorm output",
+			output: header + `[silencedetect @ 0x55] silence_start: 0
+[silencedetect @ 0x55] silence_end: 1 | silence_duration: 1
+[silencedetect @ 0x55] silence_start: 200
+[Parsed_loudnorm_1 @ 0x56] 
+{
+	"input_i" : "-9.21",
+	"input_tp" : "0.47",
+	"input_lra" : "5.60"
+}
+`,
+			duration: 202 * time.Second,
+			want:     CuePoints{In: 1, Out: 200},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Parse([]byte(tt.output), tt.duration)
+			if *got != tt.want {
+				t.Errorf("Parse = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCuePointDurations(t *testing.T) {
+	cue := CuePoints{In: 1.5044, Out: 198.7346}
+	if got, want := cue.InDuration(), 1504*time.Millisecond; got != want {
+		t.Errorf("InDuration = %v, want %v", got, want)
+	}
+	if got, want := cue.OutDuration(), 198735*time.Millisecond; got != want {
+		t.Errorf("OutDuration = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,120 @@
+package source
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// File plays audio files from the local filesystem, given either as file://
+// URLs or plain paths. Anything that is not an MP3 is transcoded with ffmpeg.
+type File struct{}
+
+// NewFile creates the local file source.
+func NewFile() *File {
+	return &File{}
+}
+
+func (f *File) Name() string {
+	return "file"
+}
+
+func (f *File) Match(u *url.URL) bool {
+	return u.Scheme == "file" || (u.Scheme == "" && u.Path != "")
+}
+
+func (f *File) ID(u *url.URL) (string, error) {
+	p, err := f.path(u)
+	if err != nil {
+		return "", err
+	}
+	return hashID(f.Name(), p), nil
+}
+
+func (f *File) Metadata(ctx context.Context, u *url.URL) (*Metadata, error) {
+	p, err := f.path(u)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := os.Stat(p); errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s does not exist", ErrUnavailable, p)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to stat %s: %w", p, err)
+	}
+
+	return &Metadata{
+		ID:          hashID(f.Name(), p),
+		Title:       titleFromPath(filepath.ToSlash(p)),
+		OriginalURL: u.String(),
+	}, nil
+}
+
+func (f *File) Fetch(ctx context.Context, u *url.URL, dest string) (*Metadata, error) {
+	meta, err := f.Metadata(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := f.path(u)
+	if err != nil {
+		return nil, err
+	}
+
+	// The cache may evict its copy, so never link to the original.
+	if strings.EqualFold(filepath.Ext(p), ".mp3") {
+		err = copyFile(p, dest)
+	} else {
+		err = transcode(ctx, p, dest)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if meta.Duration, err = ProbeDuration(dest); err != nil {
+		return nil, err
+	}
+
+	return meta, nil
+}
+
+// path returns the absolute path of the file u points to.
+func (f *File) path(u *url.URL) (string, error) {
+	p := u.Path
+	if u.Scheme == "file" && u.Host != "" && u.Host != "localhost" {
+		return "", fmt.Errorf("remote file URLs are not supported: %s", u)
+	}
+
+	abs, err := filepath.Abs(filepath.FromSlash(p))
+	if err != nil {
+		return "", fmt.Errorf("invalid path %s: %w", p, err)
+	}
+
+	return abs, nil
+}
+
+func copyFile(src, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", src, err)
+	}
+	defer in.Close()
+
+	out, err := os.Create(dest)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", dest, err)
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dest)
+		return fmt.Errorf("failed to copy %s: %w", src, err)
+	}
+
+	return out.Close()
+}
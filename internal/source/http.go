@@ -0,0 +1,135 @@
+package source
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	// httpTimeout bounds a whole download, including reading the body.
+	httpTimeout = 10 * time.Minute
+	// DefaultMaxHTTPSize is the largest file the HTTP source downloads.
+	DefaultMaxHTTPSize = 200 << 20
+)
+
+// HTTP plays audio files served directly over HTTP. Anything that is not an
+// MP3 is transcoded with ffmpeg.
+type HTTP struct {
+	// MaxSize is the largest file in bytes that is downloaded.
+	MaxSize int64
+	client  *http.Client
+}
+
+// NewHTTP creates the direct HTTP source.
+func NewHTTP() *HTTP {
+	return &HTTP{
+		MaxSize: DefaultMaxHTTPSize,
+		client:  &http.Client{Timeout: httpTimeout},
+	}
+}
+
+func (h *HTTP) Name() string {
+	return "http"
+}
+
+func (h *HTTP) Match(u *url.URL) bool {
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+func (h *HTTP) ID(u *url.URL) (string, error) {
+	return hashID(h.Name(), u.String()), nil
+}
+
+func (h *HTTP) Metadata(ctx context.Context, u *url.URL) (*Metadata, error) {
+	id, err := h.ID(u)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Metadata{
+		ID:          id,
+		Title:       titleFromPath(u.Path),
+		OriginalURL: u.String(),
+	}, nil
+}
+
+func (h *HTTP) Fetch(ctx context.Context, u *url.URL, dest string) (*Metadata, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %w", u, err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusGone, http.StatusUnavailableForLegalReasons:
+		return nil, fmt.Errorf("%w: %s returned %s", ErrUnavailable, u, resp.Status)
+	default:
+		return nil, fmt.Errorf("failed to fetch %s: %s", u, resp.Status)
+	}
+
+	if resp.ContentLength > h.MaxSize {
+		return nil, fmt.Errorf("%w: %s is %d bytes, more than the limit of %d", ErrUnavailable, u, resp.ContentLength, h.MaxSize)
+	}
+
+	part := dest + ".part"
+	f, err := os.Create(part)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", part, err)
+	}
+	defer os.Remove(part)
+
+	// Read one byte past the limit to tell a file of exactly MaxSize apart
+	// from a larger one.
+	body := io.LimitReader(resp.Body, h.MaxSize+1)
+	n, err := io.Copy(f, newProgressReader(ctx, body, resp.ContentLength))
+	f.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", u, err)
+	}
+	if n > h.MaxSize {
+		return nil, fmt.Errorf("%w: %s is larger than the limit of %d bytes", ErrUnavailable, u, h.MaxSize)
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType == "audio/mpeg" || strings.EqualFold(path.Ext(u.Path), ".mp3") {
+		if err := os.Rename(part, dest); err != nil {
+			return nil, fmt.Errorf("failed to move %s: %w", part, err)
+		}
+	} else if err := transcode(ctx, part, dest); err != nil {
+		return nil, err
+	}
+
+	meta, err := h.Metadata(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+
+	if meta.Duration, err = ProbeDuration(dest); err != nil {
+		return nil, err
+	}
+
+	return meta, nil
+}
+
+// titleFromPath uses a file name without its extension as a title.
+func titleFromPath(p string) string {
+	name := path.Base(p)
+	if unescaped, err := url.PathUnescape(name); err == nil {
+		name = unescaped
+	}
+	return strings.TrimSuffix(name, path.Ext(name))
+}
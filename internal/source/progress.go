@@ -0,0 +1,79 @@
+package source
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// Progress is how far a download has got.
+type Progress struct {
+	Downloaded int64
+	// Total is 0 when the size of the download is not known.
+	Total int64
+	// Speed is in bytes per second.
+	Speed float64
+	ETA   time.Duration
+}
+
+// Percent returns how much of the download is done, from 0 to 100.
+func (p Progress) Percent() float64 {
+	if p.Total <= 0 {
+		return 0
+	}
+	return min(100, float64(p.Downloaded)/float64(p.Total)*100)
+}
+
+// ProgressFunc is called as a download advances.
+type ProgressFunc func(Progress)
+
+type progressKey struct{}
+
+// WithProgress returns a context that makes Fetch report progress to fn.
+func WithProgress(ctx context.Context, fn ProgressFunc) context.Context {
+	return context.WithValue(ctx, progressKey{}, fn)
+}
+
+// reportProgress passes p to the ProgressFunc of ctx, if any.
+func reportProgress(ctx context.Context, p Progress) {
+	if fn, ok := ctx.Value(progressKey{}).(ProgressFunc); ok {
+		fn(p)
+	}
+}
+
+// progressReader reports the bytes read through it as progress.
+type progressReader struct {
+	ctx     context.Context
+	r       io.Reader
+	total   int64
+	read    int64
+	started time.Time
+}
+
+func newProgressReader(ctx context.Context, r io.Reader, total int64) *progressReader {
+	return &progressReader{
+		ctx:     ctx,
+		r:       r,
+		total:   max(total, 0),
+		started: time.Now(),
+	}
+}
+
+func (pr *progressReader) Read(b []byte) (int, error) {
+	n, err := pr.r.Read(b)
+	pr.read += int64(n)
+
+	p := Progress{
+		Downloaded: pr.read,
+		Total:      pr.total,
+	}
+	if elapsed := time.Since(pr.started).Seconds(); elapsed > 0 {
+		p.Speed = float64(pr.read) / elapsed
+	}
+	if p.Speed > 0 && pr.total > pr.read {
+		p.ETA = time.Duration(float64(pr.total-pr.read) / p.Speed * float64(time.Second))
+	}
+	reportProgress(pr.ctx, p)
+
+	return n, err
+}
@@ -0,0 +1,171 @@
+package source
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/feline-dis/go-radio/internal/loudness"
+	"github.com/feline-dis/go-radio/internal/mp3"
+	"github.com/feline-dis/go-radio/internal/silence"
+)
+
+var (
+	// ErrUnsupported is returned for URLs no source can handle.
+	ErrUnsupported = errors.New("no source supports this url")
+	// ErrUnavailable is returned when a song is gone for good, e.g. because
+	// it was removed, made private or is blocked in this region. Retrying
+	// will not help.
+	ErrUnavailable = errors.New("song is unavailable")
+)
+
+// Metadata describes a song as reported by its source. It is stored next to
+// the downloaded audio in the cache.
+type Metadata struct {
+	ID          string `json:"id"`
+	Title       string `json:"title"`
+	Artist      string `json:"artist,omitempty"`
+	Thumbnail   string `json:"thumbnail"`
+	Duration    int    `json:"duration"`
+	OriginalURL string `json:"original_url"`
+	// Loudness is measured after the song is downloaded. GainDB is the gain
+	// that normalizes the song, computed from it.
+	Loudness *loudness.Measurement `json:"loudness,omitempty"`
+	GainDB   float64               `json:"gain_db,omitempty"`
+	// Cue skips the silence at the start and end of the song.
+	Cue *silence.CuePoints `json:"cue,omitempty"`
+}
+
+// Source is a backend songs can be played from.
+type Source interface {
+	// Name identifies the source in logs and song IDs.
+	Name() string
+	// Match reports whether the source handles u.
+	Match(u *url.URL) bool
+	// ID returns a stable, filename safe ID for the song at u.
+	ID(u *url.URL) (string, error)
+	// Metadata looks up the song without downloading its audio.
+	Metadata(ctx context.Context, u *url.URL) (*Metadata, error)
+	// Fetch downloads the song as an MP3 to dest.
+	Fetch(ctx context.Context, u *url.URL, dest string) (*Metadata, error)
+}
+
+var (
+	sources = []Source{
+		NewYouTube(),
+		NewYtdlp("soundcloud", "soundcloud.com"),
+		NewYtdlp("bandcamp", "bandcamp.com"),
+		NewHTTP(),
+		NewFile(),
+	}
+	sourcesLock sync.RWMutex
+)
+
+// Register adds a source that takes precedence over the built in ones.
+func Register(source Source) {
+	sourcesLock.Lock()
+	defer sourcesLock.Unlock()
+	sources = append([]Source{source}, sources...)
+}
+
+// For returns the source for rawURL and the parsed URL.
+func For(rawURL string) (Source, *url.URL, error) {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid URL: %w", err)
+	}
+
+	sourcesLock.RLock()
+	defer sourcesLock.RUnlock()
+
+	for _, source := range sources {
+		if source.Match(u) {
+			return source, u, nil
+		}
+	}
+
+	return nil, nil, ErrUnsupported
+}
+
+// ID returns the ID of the song at rawURL.
+func ID(rawURL string) (string, error) {
+	source, u, err := For(rawURL)
+	if err != nil {
+		return "", err
+	}
+	return source.ID(u)
+}
+
+// Fetch downloads the song at rawURL as an MP3 to dest.
+func Fetch(ctx context.Context, rawURL string, dest string) (*Metadata, error) {
+	source, u, err := For(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	meta, err := source.Fetch(ctx, u, dest)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", source.Name(), err)
+	}
+
+	return meta, nil
+}
+
+// Lookup returns the metadata of the song at rawURL without downloading it.
+func Lookup(ctx context.Context, rawURL string) (*Metadata, error) {
+	source, u, err := For(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	meta, err := source.Metadata(ctx, u)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", source.Name(), err)
+	}
+
+	return meta, nil
+}
+
+// hashID builds an ID from the source name and a hash of key.
+func hashID(name, key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return name + "-" + hex.EncodeToString(sum[:8])
+}
+
+// transcode converts input to an MP3 at dest with ffmpeg.
+func transcode(ctx context.Context, input, dest string) error {
+	cmd := exec.CommandContext(ctx, "ffmpeg",
+		"-y",
+		"-loglevel", "error",
+		"-i", input,
+		"-vn",
+		"-codec:a", "libmp3lame",
+		"-q:a", "2",
+		dest,
+	)
+
+	if output, err := cmd.CombinedOutput(); err != nil {
+		os.Remove(dest)
+		return fmt.Errorf("ffmpeg failed: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	return nil
+}
+
+// ProbeDuration returns the length of an MP3 file in whole seconds.
+func ProbeDuration(path string) (int, error) {
+	duration, err := mp3.FileDuration(path)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(duration.Round(time.Second) / time.Second), nil
+}
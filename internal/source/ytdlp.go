@@ -0,0 +1,295 @@
+package source
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/feline-dis/go-radio/internal/utils"
+)
+
+type ytdlpResponse struct {
+	ID          string `json:"id"`
+	Title       string `json:"title"`
+	Artist      string `json:"artist"`
+	Uploader    string `json:"uploader"`
+	Thumbnail   string `json:"thumbnail"`
+	Duration    int    `json:"duration"`
+	OriginalURL string `json:"original_url"`
+}
+
+// Ytdlp plays songs from any site yt-dlp supports.
+type Ytdlp struct {
+	name    string
+	domains []string
+}
+
+// NewYtdlp creates a yt-dlp backed source for URLs on domains or their
+// subdomains.
+func NewYtdlp(name string, domains ...string) *Ytdlp {
+	return &Ytdlp{
+		name:    name,
+		domains: domains,
+	}
+}
+
+func (y *Ytdlp) Name() string {
+	return y.name
+}
+
+func (y *Ytdlp) Match(u *url.URL) bool {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return false
+	}
+
+	host := strings.ToLower(u.Hostname())
+	for _, domain := range y.domains {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ID hashes the URL without its query string, which these sites only use for
+// tracking.
+func (y *Ytdlp) ID(u *url.URL) (string, error) {
+	key := strings.ToLower(u.Hostname()) + strings.TrimSuffix(u.EscapedPath(), "/")
+	return hashID(y.name, key), nil
+}
+
+func (y *Ytdlp) Metadata(ctx context.Context, u *url.URL) (*Metadata, error) {
+	stdout, err := runYtdlp(ctx, "--skip-download", "--no-playlist", "-j", u.String())
+	if err != nil {
+		return nil, err
+	}
+
+	return y.parse(u, stdout)
+}
+
+func (y *Ytdlp) Fetch(ctx context.Context, u *url.URL, dest string) (*Metadata, error) {
+	stdout, err := runYtdlp(ctx,
+		"-x",
+		"--audio-format",
+		"mp3",
+		"--no-playlist",
+		"--print-json",
+		"--newline",
+		"--progress",
+		"--progress-template",
+		progressTemplate,
+		"-o",
+		strings.TrimSuffix(dest, ".mp3")+".%(ext)s",
+		u.String(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := os.Stat(dest); err != nil {
+		return nil, fmt.Errorf("yt-dlp did not produce %s: %w", dest, err)
+	}
+
+	return y.parse(u, stdout)
+}
+
+func (y *Ytdlp) parse(u *url.URL, stdout []byte) (*Metadata, error) {
+	var response ytdlpResponse
+	if err := json.Unmarshal(stdout, &response); err != nil {
+		return nil, fmt.Errorf("failed to parse yt-dlp response: %w", err)
+	}
+
+	id, err := y.ID(u)
+	if err != nil {
+		return nil, err
+	}
+
+	artist := response.Artist
+	if artist == "" {
+		artist = response.Uploader
+	}
+
+	return &Metadata{
+		ID:          id,
+		Title:       response.Title,
+		Artist:      artist,
+		Thumbnail:   response.Thumbnail,
+		Duration:    response.Duration,
+		OriginalURL: u.String(),
+	}, nil
+}
+
+// unavailableErrors are fragments of yt-dlp errors for songs that are gone
+// for good.
+var unavailableErrors = []string{
+	"video unavailable",
+	"private video",
+	"has been removed",
+	"no longer available",
+	"account associated with this video has been terminated",
+	"not available in your country",
+	"blocked it in your country",
+	"who has blocked it on copyright grounds",
+	"http error 404",
+	"http error 410",
+}
+
+// progressPrefix starts the progress lines yt-dlp prints with
+// progressTemplate, telling them apart from its JSON output.
+const progressPrefix = "[progress] "
+
+var progressTemplate = "download:" + progressPrefix +
+	"%(progress.downloaded_bytes)s %(progress.total_bytes)s %(progress.total_bytes_estimate)s %(progress.speed)s %(progress.eta)s"
+
+// runYtdlp runs yt-dlp and returns its output, reporting progress lines to the
+// ProgressFunc of ctx instead.
+func runYtdlp(ctx context.Context, args ...string) ([]byte, error) {
+	fmt.Printf("yt-dlp %v\n", strings.Join(args, " "))
+
+	cmd := exec.CommandContext(ctx, "yt-dlp", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create pipe: %w", err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("yt-dlp failed: %w", err)
+	}
+
+	var output bytes.Buffer
+	scanner := bufio.NewScanner(stdout)
+	// The JSON of a video lists all of its formats and is easily larger
+	// than the default buffer.
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if fields, ok := strings.CutPrefix(scanner.Text(), progressPrefix); ok {
+			reportProgress(ctx, parseProgress(fields))
+			continue
+		}
+		output.Write(scanner.Bytes())
+		output.WriteByte('\n')
+	}
+
+	if err := scanner.Err(); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil, fmt.Errorf("failed to read yt-dlp output: %w", err)
+	}
+
+	if err := cmd.Wait(); err != nil {
+		if _, ok := err.(*exec.ExitError); ok {
+			return nil, ytdlpError(err, stderr.Bytes())
+		}
+		return nil, fmt.Errorf("yt-dlp failed: %w", err)
+	}
+
+	return output.Bytes(), nil
+}
+
+// parseProgress parses a line printed with progressTemplate. Fields yt-dlp
+// does not know are printed as NA and left zero.
+func parseProgress(line string) Progress {
+	values := make([]float64, 5)
+	for i, field := range strings.Fields(line) {
+		if i < len(values) {
+			values[i], _ = strconv.ParseFloat(field, 64)
+		}
+	}
+
+	total := values[1]
+	if total == 0 {
+		total = values[2]
+	}
+
+	return Progress{
+		Downloaded: int64(values[0]),
+		Total:      int64(total),
+		Speed:      values[3],
+		ETA:        time.Duration(values[4] * float64(time.Second)),
+	}
+}
+
+// ytdlpError turns a failed yt-dlp run into an error carrying the reason it
+// printed, wrapping ErrUnavailable if the song is gone for good.
+func ytdlpError(err error, stderr []byte) error {
+	reason := err.Error()
+	for _, line := range strings.Split(string(stderr), "\n") {
+		if strings.HasPrefix(line, "ERROR:") {
+			reason = strings.TrimSpace(strings.TrimPrefix(line, "ERROR:"))
+		}
+	}
+
+	lower := strings.ToLower(reason)
+	for _, fragment := range unavailableErrors {
+		if strings.Contains(lower, fragment) {
+			return fmt.Errorf("%w: %s", ErrUnavailable, reason)
+		}
+	}
+
+	return fmt.Errorf("yt-dlp failed: %s", reason)
+}
+
+var validVideoID = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// YouTube plays YouTube videos. Songs are identified by their video ID.
+type YouTube struct {
+	*Ytdlp
+}
+
+// NewYouTube creates the YouTube source.
+func NewYouTube() *YouTube {
+	return &YouTube{
+		Ytdlp: NewYtdlp("youtube", "youtube.com", "youtu.be"),
+	}
+}
+
+func (y *YouTube) ID(u *url.URL) (string, error) {
+	id, err := utils.ParseYouTubeVideoID(u.String())
+	if err != nil {
+		return "", err
+	}
+
+	if !validVideoID.MatchString(id) {
+		return "", fmt.Errorf("invalid video ID %q", id)
+	}
+
+	return id, nil
+}
+
+func (y *YouTube) Metadata(ctx context.Context, u *url.URL) (*Metadata, error) {
+	meta, err := y.Ytdlp.Metadata(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	return meta, y.setID(u, meta)
+}
+
+func (y *YouTube) Fetch(ctx context.Context, u *url.URL, dest string) (*Metadata, error) {
+	meta, err := y.Ytdlp.Fetch(ctx, u, dest)
+	if err != nil {
+		return nil, err
+	}
+	return meta, y.setID(u, meta)
+}
+
+func (y *YouTube) setID(u *url.URL, meta *Metadata) error {
+	id, err := y.ID(u)
+	if err != nil {
+		return err
+	}
+	meta.ID = id
+	return nil
+}
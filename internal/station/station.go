@@ -0,0 +1,251 @@
+package station
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/feline-dis/go-radio/internal/controller"
+	"github.com/feline-dis/go-radio/internal/download"
+	"github.com/feline-dis/go-radio/internal/history"
+	"github.com/feline-dis/go-radio/internal/ingest"
+	"github.com/feline-dis/go-radio/internal/orchestrator"
+	"github.com/feline-dis/go-radio/internal/picker"
+	"github.com/feline-dis/go-radio/internal/stream"
+)
+
+// DefaultName is the name of the station used when no stations are configured.
+const DefaultName = "main"
+
+var validName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// Config describes a single station.
+type Config struct {
+	Name string `json:"name"`
+	// IngestPath is a directory of song lists only this station plays. When
+	// empty the station plays from the shared ingest directory.
+	IngestPath string `json:"ingest_path"`
+	// Submitters limits the station to songs from these submitters. When
+	// empty every song is played.
+	Submitters []string `json:"submitters"`
+	// FairShare makes submitters take turns instead of shuffling all songs
+	// together.
+	FairShare *picker.FairShare `json:"fair_share"`
+	// CrossfadeSeconds is how long consecutive songs overlap on this
+	// station. Options.Crossfade is used when it is unset and zero disables
+	// crossfading.
+	CrossfadeSeconds *float64 `json:"crossfade_seconds"`
+}
+
+// crossfade returns how long consecutive songs overlap on the station.
+func (c *Config) crossfade(options Options) time.Duration {
+	if c.CrossfadeSeconds == nil {
+		return options.Crossfade
+	}
+	return time.Duration(*c.CrossfadeSeconds * float64(time.Second))
+}
+
+// HLSConfig configures the HLS output of every station.
+type HLSConfig struct {
+	Enabled        bool
+	SegmentLength  time.Duration
+	PlaylistWindow int
+}
+
+// Options are settings shared by every station.
+type Options struct {
+	HLS           HLSConfig
+	RequestLimits picker.RequestLimits
+	SkipThreshold orchestrator.SkipThreshold
+	Crossfade     time.Duration
+	// TrustedProxies may forward the addresses of listeners.
+	TrustedProxies controller.TrustedProxies
+}
+
+// LoadConfigs reads station configs from a JSON file containing an array of
+// Config. A single default station is returned if the file does not exist.
+func LoadConfigs(path string) ([]Config, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return []Config{{Name: DefaultName}}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read station config: %w", err)
+	}
+
+	var configs []Config
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return nil, fmt.Errorf("failed to parse station config: %w", err)
+	}
+
+	if len(configs) == 0 {
+		return nil, fmt.Errorf("no stations configured in %s", path)
+	}
+
+	seen := make(map[string]bool, len(configs))
+	for _, config := range configs {
+		if !validName.MatchString(config.Name) {
+			return nil, fmt.Errorf("invalid station name %q", config.Name)
+		}
+		if seen[config.Name] {
+			return nil, fmt.Errorf("duplicate station name %q", config.Name)
+		}
+		seen[config.Name] = true
+
+		if config.FairShare != nil {
+			if err := config.FairShare.Validate(); err != nil {
+				return nil, fmt.Errorf("invalid fair share of station %q: %w", config.Name, err)
+			}
+		}
+
+		if config.CrossfadeSeconds != nil && *config.CrossfadeSeconds < 0 {
+			return nil, fmt.Errorf("crossfade of station %q must not be negative", config.Name)
+		}
+	}
+
+	return configs, nil
+}
+
+// Station is a single independent broadcast with its own picker, orchestrator,
+// websocket room and stream.
+type Station struct {
+	config              Config
+	dataService         *ingest.DataService
+	pickerService       *picker.PickerService
+	orchestrator        *orchestrator.Orchestrator
+	websocketController *controller.WebsocketController
+	requestController   *controller.RequestController
+	broadcaster         *stream.Broadcaster
+	segmenter           *stream.Segmenter
+}
+
+// NewStation creates a station playing songs from dataService. The download
+// service and history store are shared between stations.
+func NewStation(config Config, dataService *ingest.DataService, downloadService *download.DownloadService, historyStore *history.Store, options Options) *Station {
+	requests := picker.NewRequestQueue(options.RequestLimits)
+	var strategy picker.Strategy
+	if config.FairShare != nil {
+		strategy = config.FairShare.Shuffle
+	}
+	pickerService := picker.NewPickerService(dataService, requests, strategy, config.Submitters...)
+	pickerService.SetAvailability(downloadService)
+	dataService.OnChange(func(added, removed []*ingest.Song) {
+		pickerService.SyncData()
+	})
+	broadcaster := stream.NewBroadcaster()
+
+	websocketController := controller.NewWebsocketController()
+	websocketController.TrustedProxies = options.TrustedProxies
+	requestController := controller.NewRequestController(requests, dataService)
+	requestController.TrustedProxies = options.TrustedProxies
+	requestController.RegisterHandlers(websocketController)
+
+	var segmenter *stream.Segmenter
+	if options.HLS.Enabled {
+		segmenter = stream.NewSegmenter(options.HLS.SegmentLength, options.HLS.PlaylistWindow)
+		broadcaster.AddSink(segmenter)
+	}
+
+	orc := orchestrator.NewOrchestrator(config.Name, downloadService, dataService, pickerService, websocketController, broadcaster, historyStore, orchestrator.Options{
+		SkipThreshold: options.SkipThreshold,
+		Crossfade:     config.crossfade(options),
+	})
+
+	return &Station{
+		config:              config,
+		dataService:         dataService,
+		pickerService:       pickerService,
+		orchestrator:        orc,
+		websocketController: websocketController,
+		requestController:   requestController,
+		broadcaster:         broadcaster,
+		segmenter:           segmenter,
+	}
+}
+
+// Name returns the station's name.
+func (s *Station) Name() string {
+	return s.config.Name
+}
+
+// Orchestrator returns the station's orchestrator.
+func (s *Station) Orchestrator() *orchestrator.Orchestrator {
+	return s.orchestrator
+}
+
+// DataService returns the library the station plays from.
+func (s *Station) DataService() *ingest.DataService {
+	return s.dataService
+}
+
+// Start starts broadcasting. It returns once the first song is playing.
+func (s *Station) Start() {
+	s.broadcaster.Start()
+	s.orchestrator.Start()
+}
+
+// RegisterRoutes serves the station under /ws/{name}, /stream/{name},
+// /hls/{name} and /api/stations/{name}.
+func (s *Station) RegisterRoutes(r *http.ServeMux, historyStore *history.Store) {
+	s.registerRoutes(r, historyStore, "/ws/"+s.Name(), "/stream/"+s.Name(), "/hls/"+s.Name(), "/api/stations/"+s.Name())
+}
+
+// RegisterDefaultRoutes serves the station under the unprefixed /ws, /stream,
+// /hls and /api paths.
+func (s *Station) RegisterDefaultRoutes(r *http.ServeMux, historyStore *history.Store) {
+	s.registerRoutes(r, historyStore, "/ws", "/stream", "/hls", "/api")
+}
+
+func (s *Station) registerRoutes(r *http.ServeMux, historyStore *history.Store, wsPath, streamPath, hlsPrefix, apiPrefix string) {
+	s.websocketController.RegisterRoutes(r, wsPath)
+
+	streamController := controller.NewStreamController(s.broadcaster)
+	streamController.RegisterRoutes(r, streamPath)
+
+	if s.segmenter != nil {
+		hlsController := controller.NewHLSController(s.segmenter)
+		hlsController.RegisterRoutes(r, hlsPrefix)
+	}
+
+	apiController := controller.NewAPIController(s.orchestrator, historyStore)
+	apiController.RegisterRoutes(r, apiPrefix)
+
+	s.requestController.RegisterRoutes(r, apiPrefix)
+}
+
+// Registry holds every station served by this process.
+type Registry struct {
+	stations []*Station
+}
+
+// NewRegistry creates a registry. The first station is the default station.
+func NewRegistry(stations []*Station) *Registry {
+	return &Registry{
+		stations: stations,
+	}
+}
+
+// Stations returns every station in configuration order.
+func (r *Registry) Stations() []*Station {
+	return r.stations
+}
+
+// Default returns the default station.
+func (r *Registry) Default() *Station {
+	return r.stations[0]
+}
+
+// GetSong looks a song up in the libraries of every station.
+func (r *Registry) GetSong(id string) *ingest.Song {
+	for _, station := range r.stations {
+		if song := station.dataService.GetSong(id); song != nil {
+			return song
+		}
+	}
+
+	return nil
+}
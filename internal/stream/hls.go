@@ -0,0 +1,209 @@
+package stream
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/feline-dis/go-radio/internal/mp3"
+)
+
+// timestampOwner is the ID3 PRIV owner Apple requires on packed audio segments.
+const timestampOwner = "com.apple.streaming.transportStreamTimestamp"
+
+// Segment is a short run of frames served as a single HLS media segment.
+type Segment struct {
+	Sequence        int
+	Title           string
+	Duration        time.Duration
+	ProgramDateTime time.Time
+	Discontinuity   bool
+	Data            []byte
+}
+
+// Segmenter cuts the broadcast into HLS segments and keeps a rolling window of
+// them for the live playlist.
+type Segmenter struct {
+	target   time.Duration
+	window   int
+	segments []*Segment
+	pending  *Segment
+	track    *Track
+	buf      bytes.Buffer
+	sequence int
+	// discontinuitySequence counts discontinuities that have left the playlist.
+	discontinuitySequence int
+	// timestamp is the running stream time used for segment ID3 timestamps.
+	timestamp time.Duration
+	mu        sync.RWMutex
+}
+
+// NewSegmenter creates a segmenter producing segments of roughly target
+// duration and advertising the most recent window segments.
+func NewSegmenter(target time.Duration, window int) *Segmenter {
+	return &Segmenter{
+		target: target,
+		window: window,
+	}
+}
+
+// WriteFrame implements Sink.
+func (s *Segmenter) WriteFrame(track *Track, position time.Duration, frame *mp3.Frame) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.pending != nil && track != s.track {
+		// Song boundaries always start a new segment.
+		s.flush()
+	}
+
+	if s.pending == nil {
+		// A chained track continues the same song.
+		continued := s.track != nil && s.track.Next == track
+		s.pending = &Segment{
+			Sequence:        s.sequence,
+			Title:           track.Title,
+			ProgramDateTime: track.StartTime.Add(position),
+			Discontinuity:   s.track != nil && track != s.track && !continued,
+		}
+		s.sequence++
+		s.track = track
+
+		s.buf.Reset()
+		s.buf.Write(timestampTag(s.timestamp))
+	}
+
+	s.buf.Write(frame.Data)
+	s.pending.Duration += frame.Header.Duration()
+	s.timestamp += frame.Header.Duration()
+
+	if s.pending.Duration >= s.target {
+		s.flush()
+	}
+}
+
+// flush finalises the pending segment and slides the window.
+func (s *Segmenter) flush() {
+	s.pending.Data = bytes.Clone(s.buf.Bytes())
+	s.segments = append(s.segments, s.pending)
+	s.pending = nil
+
+	// Keep some segments past the playlist window so clients that loaded an
+	// older playlist can still fetch them.
+	for len(s.segments) > s.window*2 {
+		s.segments = s.segments[1:]
+	}
+
+	if len(s.segments) > s.window {
+		if s.segments[len(s.segments)-s.window-1].Discontinuity {
+			s.discontinuitySequence++
+		}
+	}
+}
+
+// Segment returns the segment with the given sequence number.
+func (s *Segmenter) Segment(sequence int) (*Segment, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, segment := range s.segments {
+		if segment.Sequence == sequence {
+			return segment, true
+		}
+	}
+
+	return nil, false
+}
+
+// TargetDuration returns the maximum segment duration advertised in the playlist.
+func (s *Segmenter) TargetDuration() time.Duration {
+	return s.target
+}
+
+// Playlist renders the live media playlist. uri formats a segment sequence
+// number into the URI clients should fetch it from.
+func (s *Segmenter) Playlist(uri func(sequence int) string) []byte {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	segments := s.segments
+	if len(segments) > s.window {
+		segments = segments[len(segments)-s.window:]
+	}
+
+	target := s.target
+	for _, segment := range segments {
+		target = max(target, segment.Duration)
+	}
+
+	var b bytes.Buffer
+	b.WriteString("#EXTM3U\n")
+	b.WriteString("#EXT-X-VERSION:3\n")
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", int(math.Ceil(target.Seconds())))
+	if len(segments) > 0 {
+		fmt.Fprintf(&b, "#EXT-X-MEDIA-SEQUENCE:%d\n", segments[0].Sequence)
+	}
+	fmt.Fprintf(&b, "#EXT-X-DISCONTINUITY-SEQUENCE:%d\n", s.discontinuitySequence)
+
+	for _, segment := range segments {
+		if segment.Discontinuity {
+			b.WriteString("#EXT-X-DISCONTINUITY\n")
+		}
+		fmt.Fprintf(&b, "#EXT-X-PROGRAM-DATE-TIME:%s\n", segment.ProgramDateTime.UTC().Format("2006-01-02T15:04:05.000Z"))
+		fmt.Fprintf(&b, "#EXTINF:%.3f,%s\n", segment.Duration.Seconds(), playlistTitle(segment.Title))
+		b.WriteString(uri(segment.Sequence))
+		b.WriteString("\n")
+	}
+
+	return b.Bytes()
+}
+
+// playlistTitle removes control characters from title, so a line break in a
+// song's title cannot end the EXTINF tag and add lines to the playlist.
+func playlistTitle(title string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, title)
+}
+
+// timestampTag builds the ID3v2.4 tag carrying the 90kHz MPEG-2 timestamp that
+// HLS requires at the start of every packed audio segment.
+func timestampTag(timestamp time.Duration) []byte {
+	ticks := uint64(timestamp.Seconds()*90000) & (1<<33 - 1)
+
+	data := make([]byte, 0, len(timestampOwner)+1+8)
+	data = append(data, timestampOwner...)
+	data = append(data, 0)
+	data = binary.BigEndian.AppendUint64(data, ticks)
+
+	frame := make([]byte, 0, 10+len(data))
+	frame = append(frame, "PRIV"...)
+	frame = append(frame, syncsafe(len(data))...)
+	frame = append(frame, 0, 0)
+	frame = append(frame, data...)
+
+	tag := make([]byte, 0, 10+len(frame))
+	tag = append(tag, "ID3"...)
+	tag = append(tag, 4, 0, 0)
+	tag = append(tag, syncsafe(len(frame))...)
+	tag = append(tag, frame...)
+
+	return tag
+}
+
+func syncsafe(n int) []byte {
+	return []byte{
+		byte(n>>21) & 0x7F,
+		byte(n>>14) & 0x7F,
+		byte(n>>7) & 0x7F,
+		byte(n) & 0x7F,
+	}
+}
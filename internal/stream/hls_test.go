@@ -0,0 +1,52 @@
+package stream
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSegmenterDiscontinuity(t *testing.T) {
+	frame := newFrame(t)
+	s := NewSegmenter(2*frame.Header.Duration(), 10)
+
+	// A crossfade mix is chained to the song it fades into, so switching
+	// between them continues the same song.
+	song := &Track{ID: "song", Title: "Song"}
+	mix := &Track{ID: "song", Title: "Song", Next: song}
+	other := &Track{ID: "other", Title: "Other"}
+
+	tracks := []struct {
+		track  *Track
+		frames int
+	}{
+		{track: mix, frames: 3},
+		{track: song, frames: 2},
+		{track: other, frames: 2},
+	}
+	for _, tt := range tracks {
+		for i := 0; i < tt.frames; i++ {
+			s.WriteFrame(tt.track, time.Duration(i)*frame.Header.Duration(), frame)
+		}
+	}
+
+	// Song boundaries cut the mix's second segment short.
+	want := []bool{false, false, false, true}
+	for sequence, discontinuity := range want {
+		segment, ok := s.Segment(sequence)
+		if !ok {
+			t.Fatalf("segment %d was not cut", sequence)
+		}
+		if segment.Discontinuity != discontinuity {
+			t.Errorf("segment %d of %s has discontinuity %v, want %v", sequence, segment.Title, segment.Discontinuity, discontinuity)
+		}
+	}
+
+	playlist := string(s.Playlist(func(sequence int) string {
+		return fmt.Sprintf("%d.mp3", sequence)
+	}))
+	if got := strings.Count(playlist, "#EXT-X-DISCONTINUITY\n"); got != 1 {
+		t.Errorf("playlist has %d discontinuities, want 1:\n%s", got, playlist)
+	}
+}
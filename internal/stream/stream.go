@@ -0,0 +1,309 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/feline-dis/go-radio/internal/mp3"
+)
+
+// listenerBuffer is the number of frames a listener may fall behind before it is dropped.
+const listenerBuffer = 256
+
+// burstDuration is how much recently broadcast audio a new listener receives
+// immediately so players can fill their buffer without waiting in real time.
+const burstDuration = 3 * time.Second
+
+// Track is a single audio file to be broadcast.
+type Track struct {
+	ID        string
+	Title     string
+	Path      string
+	StartTime time.Time
+	// Gain in dB is applied to every frame, in steps of mp3.GainStep.
+	Gain float64
+	// CueIn and CueOut limit playback to part of the file. StartTime is when
+	// CueIn went to air. A zero CueOut plays to the end of the file.
+	CueIn  time.Duration
+	CueOut time.Duration
+	// Next is played straight after this track ends, without waiting for
+	// another call to Play.
+	Next *Track
+}
+
+// Sink receives every broadcast frame along with the track it belongs to and
+// the frame's position within that track, measured from its cue-in.
+type Sink interface {
+	WriteFrame(track *Track, position time.Duration, frame *mp3.Frame)
+}
+
+// Listener receives the broadcast as whole MP3 frames.
+type Listener struct {
+	C    chan []byte
+	once sync.Once
+}
+
+func (l *Listener) close() {
+	l.once.Do(func() {
+		close(l.C)
+	})
+}
+
+// Broadcaster reads the current track in real time and fans its frames out to
+// every connected listener, so all listeners hear the same position.
+type Broadcaster struct {
+	listeners map[*Listener]struct{}
+	sinks     []Sink
+	burst     []*mp3.Frame
+	current   *Track
+	tracks    chan *Track
+	mu        sync.RWMutex
+	ctx       context.Context
+	cancel    context.CancelFunc
+}
+
+// NewBroadcaster creates a new broadcaster.
+func NewBroadcaster() *Broadcaster {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Broadcaster{
+		listeners: make(map[*Listener]struct{}),
+		tracks:    make(chan *Track, 1),
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+}
+
+// Start starts the broadcast loop.
+func (b *Broadcaster) Start() {
+	go b.run()
+}
+
+// Stop stops the broadcast loop and disconnects all listeners.
+func (b *Broadcaster) Stop() {
+	b.cancel()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for l := range b.listeners {
+		l.close()
+		delete(b.listeners, l)
+	}
+}
+
+// Play switches the broadcast to the given track at the next frame boundary.
+// A track that is still pending is replaced.
+func (b *Broadcaster) Play(track *Track) {
+	for {
+		select {
+		case b.tracks <- track:
+			return
+		default:
+		}
+
+		// drop the pending track so the newest one wins
+		select {
+		case <-b.tracks:
+		default:
+		}
+	}
+}
+
+// AddSink registers a sink that receives every frame. Sinks must not block.
+func (b *Broadcaster) AddSink(sink Sink) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.sinks = append(b.sinks, sink)
+}
+
+// Current returns the track that is currently being broadcast.
+func (b *Broadcaster) Current() *Track {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.current
+}
+
+// AddListener registers a new listener. The listener immediately receives a
+// short burst of recent audio followed by the live broadcast.
+func (b *Broadcaster) AddListener() *Listener {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	l := &Listener{
+		C: make(chan []byte, listenerBuffer+len(b.burst)),
+	}
+
+	for _, frame := range b.burst {
+		l.C <- frame.Data
+	}
+
+	b.listeners[l] = struct{}{}
+	return l
+}
+
+// RemoveListener unregisters a listener and closes its channel.
+func (b *Broadcaster) RemoveListener(l *Listener) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.listeners, l)
+	l.close()
+}
+
+// ListenerCount returns the number of connected listeners.
+func (b *Broadcaster) ListenerCount() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.listeners)
+}
+
+func (b *Broadcaster) run() {
+	var (
+		file     *os.File
+		reader   *mp3.Reader
+		track    *Track
+		position time.Duration
+		clock    time.Time
+		gain     int
+		// chained is the track to play once the current one ends.
+		chained *Track
+	)
+
+	closeFile := func() {
+		if file != nil {
+			file.Close()
+		}
+		file = nil
+		reader = nil
+	}
+	defer closeFile()
+
+	for {
+		// Switch tracks between frames so song boundaries stay frame-aligned.
+		var next *Track
+		if reader == nil && chained != nil {
+			// a track passed to Play takes precedence over the chained one
+			select {
+			case next = <-b.tracks:
+			case <-b.ctx.Done():
+				return
+			default:
+				next = chained
+			}
+		} else if reader == nil {
+			select {
+			case next = <-b.tracks:
+			case <-b.ctx.Done():
+				return
+			}
+		} else {
+			select {
+			case next = <-b.tracks:
+			case <-b.ctx.Done():
+				return
+			default:
+			}
+		}
+
+		if next != nil {
+			closeFile()
+			chained = next.Next
+
+			f, err := os.Open(next.Path)
+			if err != nil {
+				fmt.Printf("Broadcaster failed to open %s: %v\n", next.Path, err)
+				continue
+			}
+
+			file = f
+			reader = mp3.NewReader(f)
+
+			b.mu.Lock()
+			b.current = next
+			b.mu.Unlock()
+
+			track = next
+			gain = mp3.GainSteps(next.Gain)
+			position, err = b.seek(reader, next.CueIn+time.Since(next.StartTime))
+			position -= next.CueIn
+			if err != nil {
+				fmt.Printf("Broadcaster failed to seek %s: %v\n", next.Path, err)
+				closeFile()
+				continue
+			}
+
+			// Restart the clock if we fell behind while idle.
+			if time.Until(clock) < -time.Second {
+				clock = time.Now()
+			}
+		}
+
+		if track.CueOut > 0 && track.CueIn+position >= track.CueOut {
+			closeFile()
+			continue
+		}
+
+		frame, err := reader.ReadFrame()
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				fmt.Printf("Broadcaster failed to read frame: %v\n", err)
+			}
+			closeFile()
+			continue
+		}
+
+		frame.AdjustGain(gain)
+		b.send(track, position, frame)
+
+		position += frame.Header.Duration()
+		clock = clock.Add(frame.Header.Duration())
+		time.Sleep(time.Until(clock))
+	}
+}
+
+// seek discards frames until offset into the track has been reached and
+// returns the position the reader was left at.
+func (b *Broadcaster) seek(reader *mp3.Reader, offset time.Duration) (time.Duration, error) {
+	var position time.Duration
+	for position < offset {
+		frame, err := reader.ReadFrame()
+		if err != nil {
+			return position, err
+		}
+		position += frame.Header.Duration()
+	}
+	return position, nil
+}
+
+func (b *Broadcaster) send(track *Track, position time.Duration, frame *mp3.Frame) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, sink := range b.sinks {
+		sink.WriteFrame(track, position, frame)
+	}
+
+	b.burst = append(b.burst, frame)
+	var buffered time.Duration
+	for i := len(b.burst) - 1; i >= 0; i-- {
+		buffered += b.burst[i].Header.Duration()
+		if buffered > burstDuration {
+			b.burst = b.burst[i+1:]
+			break
+		}
+	}
+
+	for l := range b.listeners {
+		select {
+		case l.C <- frame.Data:
+		default:
+			// listener is too slow to keep up, disconnect it
+			delete(b.listeners, l)
+			l.close()
+		}
+	}
+}
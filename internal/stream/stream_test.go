@@ -0,0 +1,103 @@
+package stream
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/feline-dis/go-radio/internal/mp3"
+)
+
+// header is an MPEG1 Layer III, 128 kbps, 44.1 kHz frame header.
+var header = []byte{0xFF, 0xFB, 0x90, 0x00}
+
+// newFrame returns a zeroed frame.
+func newFrame(t *testing.T) *mp3.Frame {
+	t.Helper()
+
+	h, err := mp3.ParseHeader(header)
+	if err != nil {
+		t.Fatalf("ParseHeader(% x): %v", header, err)
+	}
+
+	data := make([]byte, h.Size())
+	copy(data, header)
+	return &mp3.Frame{Header: h, Data: data}
+}
+
+// writeTrack writes an MP3 file of the given number of frames.
+func writeTrack(t *testing.T, name string, frames int) string {
+	t.Helper()
+
+	var b bytes.Buffer
+	for i := 0; i < frames; i++ {
+		b.Write(newFrame(t).Data)
+	}
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, b.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// sentFrame is where a frame a Broadcaster passed to its sinks was played.
+type sentFrame struct {
+	track    *Track
+	position time.Duration
+}
+
+type recordingSink chan sentFrame
+
+func (s recordingSink) WriteFrame(track *Track, position time.Duration, frame *mp3.Frame) {
+	s <- sentFrame{track: track, position: position}
+}
+
+func TestBroadcasterPlaysChainedTrack(t *testing.T) {
+	const frames = 4
+	frameDuration := newFrame(t).Header.Duration()
+
+	now := time.Now()
+	second := &Track{
+		ID:        "second",
+		Path:      writeTrack(t, "second.mp3", frames),
+		StartTime: now.Add(frames * frameDuration),
+	}
+	first := &Track{
+		ID:        "first",
+		Path:      writeTrack(t, "first.mp3", frames),
+		StartTime: now,
+		Next:      second,
+	}
+
+	sink := make(recordingSink, 2*frames)
+	b := NewBroadcaster()
+	b.AddSink(sink)
+	b.Start()
+	defer b.Stop()
+	b.Play(first)
+
+	// The broadcast catches up with StartTime, so the first frames of a
+	// track may be skipped.
+	var previous *sentFrame
+	for previous == nil || previous.track != second {
+		select {
+		case sent := <-sink:
+			if previous == nil && sent.track != first {
+				t.Fatalf("broadcast started with %s, want %s", sent.track.ID, first.ID)
+			}
+			if previous != nil && sent.track == previous.track && sent.position != previous.position+frameDuration {
+				t.Fatalf("%s jumped from %v to %v", sent.track.ID, previous.position, sent.position)
+			}
+			previous = &sent
+		case <-time.After(time.Second):
+			t.Fatal("chained track was not broadcast")
+		}
+	}
+
+	if current := b.Current(); current != second {
+		t.Errorf("Current() = %s, want %s", current.ID, second.ID)
+	}
+}
@@ -15,7 +15,7 @@ func ParseYouTubeVideoID(youtubeURL string) (string, error) {
 
 	// Check if the host is YouTube or youtu.be
 	switch parsedURL.Host {
-	case "www.youtube.com", "youtube.com":
+	case "www.youtube.com", "youtube.com", "m.youtube.com", "music.youtube.com":
 		// Extract video ID from query parameters
 		queryParams := parsedURL.Query()
 		if videoID, exists := queryParams["v"]; exists && len(videoID) > 0 {
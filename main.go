@@ -1,14 +1,22 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"net/http"
+	"os"
+	"time"
 
 	"github.com/feline-dis/go-radio/internal/controller"
 	"github.com/feline-dis/go-radio/internal/download"
+	"github.com/feline-dis/go-radio/internal/events"
+	"github.com/feline-dis/go-radio/internal/history"
 	"github.com/feline-dis/go-radio/internal/ingest"
+	"github.com/feline-dis/go-radio/internal/loudness"
+	"github.com/feline-dis/go-radio/internal/notify"
 	"github.com/feline-dis/go-radio/internal/orchestrator"
 	"github.com/feline-dis/go-radio/internal/picker"
+	"github.com/feline-dis/go-radio/internal/station"
 )
 
 type ServerConfig struct {
@@ -17,50 +25,179 @@ type ServerConfig struct {
 	InjestPath string
 	CachePath  string
 	MaxWorkers int
+	// MaxCacheSize is the number of bytes downloaded songs may take up in
+	// CachePath. The least recently played songs are evicted beyond it.
+	MaxCacheSize int64
+	// LoudnessTarget is the integrated loudness in LUFS every song is
+	// normalized to.
+	LoudnessTarget float64
+	// IngestPollInterval is how often ingest directories are checked for
+	// added, changed or removed song lists.
+	IngestPollInterval time.Duration
+	// StationsPath is a JSON file listing the stations to run. A single
+	// station playing everything in InjestPath is run if it does not exist.
+	StationsPath string
+	// HistoryPath is the SQLite database play history is stored in. History
+	// is only kept in memory when empty.
+	HistoryPath string
+	// HLS enables the segmented HLS output alongside the continuous stream.
+	HLS               bool
+	HLSSegmentLength  time.Duration
+	HLSPlaylistWindow int
+	// RequestLimits bounds listener song requests on every station.
+	RequestLimits picker.RequestLimits
+	// SkipThreshold is how many listener votes skip the current song.
+	SkipThreshold orchestrator.SkipThreshold
+	// AdminWebhookURL is a Discord compatible webhook admins are notified
+	// through, e.g. when a song becomes unavailable. Disabled when empty.
+	AdminWebhookURL string
+	// Crossfade is how long consecutive songs overlap. Zero disables
+	// crossfading.
+	Crossfade time.Duration
+	// TrustedProxies are the comma separated addresses or CIDR ranges of
+	// reverse proxies whose X-Forwarded-For header is believed.
+	TrustedProxies string
 }
 
 type Server struct {
 	config          ServerConfig
 	downloadService *download.DownloadService
-	dataService     *ingest.DataService
+	stations        *station.Registry
+	historyStore    *history.Store
 	router          *http.ServeMux
 }
 
 func NewServer(config ServerConfig) *Server {
 	router := http.NewServeMux()
 
-	dataService := ingest.NewDataService(config.InjestPath, config.MaxWorkers)
-	dataService.Start()
-
-	if err := dataService.Ingest(); err != nil {
-		fmt.Printf("failed to ingest: %v", err)
+	stationConfigs, err := station.LoadConfigs(config.StationsPath)
+	if err != nil {
+		panic(fmt.Sprintf("failed to load stations: %v", err))
 	}
 
-	dataService.WaitForJobs()
-	dataService.Stop()
+	downloadService := download.NewDownloadService(config.CachePath, config.MaxWorkers)
+	downloadService.LoudnessTarget = config.LoudnessTarget
+	downloadService.MaxCacheSize = config.MaxCacheSize
+	downloadService.Events = events.NewBus()
+	if err := downloadService.LoadCache(); err != nil {
+		fmt.Printf("failed to load cache: %v\n", err)
+	}
+	if config.AdminWebhookURL != "" {
+		webhook := notify.NewWebhook(config.AdminWebhookURL)
+		downloadService.OnUnavailable(func(song *ingest.Song, reason error) {
+			message := fmt.Sprintf("%s - %s (%s) is unavailable and was taken out of rotation: %v", song.Artist, song.Title, song.URL, reason)
+			go func() {
+				if err := webhook.Notify(context.Background(), message); err != nil {
+					fmt.Printf("failed to notify admins: %v\n", err)
+				}
+			}()
+		})
+	}
+	downloadService.Start()
+
+	var historyStore *history.Store
+	if config.HistoryPath != "" {
+		store, err := history.NewStore(config.HistoryPath)
+		if err != nil {
+			fmt.Printf("failed to open history store: %v\n", err)
+		} else {
+			historyStore = store
+		}
+	}
 
-	fmt.Println("ingest complete")
+	trustedProxies, err := controller.ParseTrustedProxies(config.TrustedProxies)
+	if err != nil {
+		panic(fmt.Sprintf("failed to load trusted proxies: %v", err))
+	}
 
-	downloadService := download.NewDownloadService(config.CachePath, config.MaxWorkers)
-	pickerService := picker.NewPickerService(dataService)
+	options := station.Options{
+		HLS: station.HLSConfig{
+			Enabled:        config.HLS,
+			SegmentLength:  config.HLSSegmentLength,
+			PlaylistWindow: config.HLSPlaylistWindow,
+		},
+		RequestLimits: config.RequestLimits,
+		SkipThreshold: config.SkipThreshold,
+		Crossfade:     config.Crossfade,
+
+		TrustedProxies: trustedProxies,
+	}
 
-	webSocketController := controller.NewWebsocketController()
-	webSocketController.RegisterRoutes(router)
+	// Stations with the same ingest directory share a data service.
+	dataServices := make(map[string]*ingest.DataService)
+	stations := make([]*station.Station, 0, len(stationConfigs))
+	for _, stationConfig := range stationConfigs {
+		ingestPath := stationConfig.IngestPath
+		if ingestPath == "" {
+			ingestPath = config.InjestPath
+		}
+
+		dataService, exists := dataServices[ingestPath]
+		if !exists {
+			dataService = loadDataService(ingestPath, config.MaxWorkers, config.IngestPollInterval)
+			dataServices[ingestPath] = dataService
+		}
+
+		stations = append(stations, station.NewStation(stationConfig, dataService, downloadService, historyStore, options))
+	}
+	registry := station.NewRegistry(stations)
 
-	fileController := controller.NewFileController(router, downloadService, dataService)
+	fileController := controller.NewFileController(router, downloadService, registry)
 	fileController.RegisterRoutes()
 
-	orc := orchestrator.NewOrchestrator(downloadService, pickerService, webSocketController)
-	orc.Start()
+	artController := controller.NewArtController(router, registry)
+	artController.RegisterRoutes()
+
+	apiStations := make([]controller.Station, 0, len(stations))
+	for _, s := range stations {
+		s.RegisterRoutes(router, historyStore)
+		apiStations = append(apiStations, s.Orchestrator())
+	}
+	registry.Default().RegisterDefaultRoutes(router, historyStore)
+
+	stationsController := controller.NewStationsController(apiStations)
+	stationsController.RegisterRoutes(router)
+
+	protocolController := controller.NewProtocolController()
+	protocolController.RegisterRoutes(router)
+
+	adminController := controller.NewAdminController(downloadService, registry)
+	adminController.RegisterRoutes(router)
+
+	for _, s := range stations {
+		go s.Start()
+	}
 
 	return &Server{
 		config:          config,
 		downloadService: downloadService,
-		dataService:     dataService,
+		stations:        registry,
+		historyStore:    historyStore,
 		router:          router,
 	}
 }
 
+// loadDataService ingests every song list in ingestPath and keeps watching it
+// for changes.
+func loadDataService(ingestPath string, maxWorkers int, pollInterval time.Duration) *ingest.DataService {
+	dataService := ingest.NewDataService(ingestPath, maxWorkers)
+	dataService.Start()
+
+	if err := dataService.Ingest(); err != nil {
+		fmt.Printf("failed to ingest: %v", err)
+	}
+
+	dataService.WaitForJobs()
+
+	fmt.Println("ingest complete:", ingestPath)
+
+	if pollInterval > 0 {
+		dataService.Watch(pollInterval)
+	}
+
+	return dataService
+}
+
 func (s *Server) Start() {
 	fmt.Println("starting server")
 
@@ -69,11 +206,30 @@ func (s *Server) Start() {
 
 func main() {
 	config := ServerConfig{
-		Host:       "localhost",
-		Port:       8080,
-		InjestPath: "./ingest",
-		CachePath:  "./cache",
-		MaxWorkers: 4,
+		Host:         "localhost",
+		Port:         8080,
+		InjestPath:   "./ingest",
+		CachePath:    "./cache",
+		MaxWorkers:   4,
+		StationsPath: "./stations.json",
+		MaxCacheSize: 10 << 30, // 10 GiB
+
+		LoudnessTarget: loudness.DefaultTarget,
+
+		IngestPollInterval: 10 * time.Second,
+
+		HistoryPath: "./data/history.db",
+
+		HLS:               true,
+		HLSSegmentLength:  6 * time.Second,
+		HLSPlaylistWindow: 6,
+
+		RequestLimits: picker.DefaultRequestLimits,
+		SkipThreshold: orchestrator.DefaultSkipThreshold,
+
+		AdminWebhookURL: os.Getenv("ADMIN_WEBHOOK_URL"),
+
+		TrustedProxies: os.Getenv("TRUSTED_PROXIES"),
 	}
 
 	NewServer(config).Start()
@@ -7,6 +7,8 @@ export default function RadioPlayer() {
     songInfo,
     isPlaying,
     elapsed,
+    buffering,
+    queue,
     audioRef,
     volume,
     togglePausePlay,
@@ -48,6 +50,16 @@ export default function RadioPlayer() {
           <div className="mb-4">
             <h2 className="text-sm font-medium truncate">{songInfo.title}</h2>
             <p className="text-xs text-gray-400 truncate">{songInfo.artist}</p>
+            {songInfo.submitter ? (
+              <div className="flex items-center gap-1 mt-1 text-xs text-gray-400">
+                <img
+                  src={songInfo.submitter.pfp_url || "/fallback.jpg"}
+                  alt={songInfo.submitter.name}
+                  className="w-4 h-4 rounded-full object-cover"
+                />
+                <span className="truncate">picked by {songInfo.submitter.name}</span>
+              </div>
+            ) : null}
           </div>
 
           {/* Progress Bar */}
@@ -62,6 +74,9 @@ export default function RadioPlayer() {
             {/* Time */}
             <div className="flex justify-between text-xs text-gray-400">
               <span>{formatTime(elapsed)}</span>
+              {buffering && elapsed >= songInfo.duration ? (
+                <span>Buffering next track {Math.floor(buffering.percent)}%</span>
+              ) : null}
               <span>{formatTime(songInfo.duration)}</span>
             </div>
           </div>
@@ -116,6 +131,29 @@ export default function RadioPlayer() {
           )}
         </button>
       </div>
+      {/* Up Next */}
+      {queue.length > 0 ? (
+        <div className="mt-6">
+          <h3 className="text-xs text-gray-400 mb-2">Up next</h3>
+          <ul className="space-y-2">
+            {queue.slice(0, 5).map((entry, i) => (
+              <li key={`${entry.song.id}-${i}`} className="flex items-center gap-2 text-xs">
+                <img
+                  src={entry.song.submitter?.pfp_url || "/fallback.jpg"}
+                  alt={entry.song.submitter?.name ?? ""}
+                  className="w-5 h-5 rounded-full object-cover"
+                />
+                <span className="truncate">
+                  {entry.song.artist} - {entry.song.title}
+                </span>
+                {entry.requested_by ? (
+                  <span className="text-gray-400 truncate">requested by {entry.requested_by}</span>
+                ) : null}
+              </li>
+            ))}
+          </ul>
+        </div>
+      ) : null}
       <audio ref={audioRef} onEnded={() => setIsPlaying(false)} />
     </div>
   )
@@ -6,9 +6,29 @@ interface SongInfo {
   title: string;
   art_url: string;
   duration: number;
+  gain_db: number;
+  cue_in_ms: number;
   start_time: string;
   end_time: string;
   id: string;
+  submitter?: { name: string; pfp_url: string };
+}
+
+interface DownloadProgress {
+  id: string;
+  state: "queued" | "downloading" | "done" | "failed";
+  percent: number;
+}
+
+interface QueueEntry {
+  song: {
+    id: string;
+    artist: string;
+    title: string;
+    art_url: string;
+    submitter?: { name: string; pfp_url: string };
+  };
+  requested_by?: string;
 }
 
 interface Message {
@@ -19,11 +39,17 @@ interface Message {
 const audioContext = new AudioContext();
 const gainNode = audioContext.createGain();
 gainNode.connect(audioContext.destination);
+// Applies the loudness normalization gain of the current song.
+const normalizeNode = audioContext.createGain();
+normalizeNode.connect(gainNode);
 
 export const useRadioPlayer = () => {
   const [songInfo, setSongInfo] = useState<SongInfo | null>(null);
   const [isPlaying, setIsPlaying] = useState(false);
   const [elapsed, setElapsed] = useState(0);
+  // Download of the next song, shown while the station waits for it.
+  const [buffering, setBuffering] = useState<DownloadProgress | null>(null);
+  const [queue, setQueue] = useState<QueueEntry[]>([]);
   const audioRef = useRef<HTMLAudioElement | null>(null);
   const audioSourceRef = useRef<AudioBufferSourceNode | null>(null);
   const wsRef = useRef<WebSocket | null>(null);
@@ -40,7 +66,7 @@ export const useRadioPlayer = () => {
     const audioBuffer = await audioContext.decodeAudioData(buffer);
     const source = audioContext.createBufferSource();
     source.buffer = audioBuffer;
-    source.connect(gainNode); // Connect to gain node instead of destination
+    source.connect(normalizeNode); // Connect to gain node instead of destination
     return source;
   };
 
@@ -48,12 +74,28 @@ export const useRadioPlayer = () => {
     wsRef.current = new WebSocket("/ws");
     wsRef.current.onopen = () => {
       console.log("WebSocket connected");
+      wsRef.current?.send(
+        JSON.stringify({ type: "hello", id: "hello", payload: { protocol_version: 1, client: "web" } })
+      );
     };
     wsRef.current.onerror = (error) => {
       console.error("WebSocket error:", error);
     };
     wsRef.current.onmessage = async (event) => {
       const data = JSON.parse(event.data) as Message;
+      if (data.type === "download_progress") {
+        const progress = data.payload as unknown as DownloadProgress;
+        const active = progress.state === "queued" || progress.state === "downloading";
+        setBuffering(active ? progress : null);
+        return;
+      }
+      if (data.type === "queue") {
+        setQueue((data.payload as unknown as { songs: QueueEntry[] }).songs);
+        return;
+      }
+      if (data.type !== "current_song") return;
+      setBuffering(null);
+
       const source = await getAudioData(data.payload.id);
       const elapsed = getElapsedTime(new Date(data.payload.start_time));
 
@@ -64,8 +106,10 @@ export const useRadioPlayer = () => {
         audioSourceRef.current.disconnect();
       }
 
+      normalizeNode.gain.value = Math.pow(10, (data.payload.gain_db ?? 0) / 20);
+
       audioSourceRef.current = source;
-      audioSourceRef.current.start(0, elapsed);
+      audioSourceRef.current.start(0, elapsed + (data.payload.cue_in_ms ?? 0) / 1000);
 
       const srcObj = audioContext.createMediaStreamDestination();
       audioSourceRef.current.connect(srcObj);
@@ -108,9 +152,9 @@ export const useRadioPlayer = () => {
     } else {
       const bfrSrc = audioContext.createBufferSource();
       bfrSrc.buffer = audioSourceRef.current.buffer!;
-      bfrSrc.connect(gainNode); // Connect to gain node instead of destination
+      bfrSrc.connect(normalizeNode); // Connect to gain node instead of destination
       audioSourceRef.current = bfrSrc;
-      audioSourceRef.current.start(0, elapsed);
+      audioSourceRef.current.start(0, elapsed + (songInfo?.cue_in_ms ?? 0) / 1000);
     }
 
     setIsPlaying(!isPlaying);
@@ -128,6 +172,8 @@ export const useRadioPlayer = () => {
     songInfo,
     isPlaying,
     elapsed,
+    buffering,
+    queue,
     audioRef,
     setIsPlaying,
     togglePausePlay,